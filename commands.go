@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/executor"
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// run executes a single config synchronously
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	source := configFlags(flags)
+	workDir := flags.String("work-dir", os.Getenv("MDF_WORK_DIR"), "Root that relative connector paths are resolved against")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mdf run [flags] <id>")
+		return EXIT_USAGE
+	}
+	id := flags.Arg(0)
+
+	configs, code := source.load()
+	if code != EXIT_OK {
+		return code
+	}
+	index := slices.IndexFunc(*configs, func(c parser.Config) bool { return c.Id == id })
+	if index < 0 {
+		slog.Error("Config not found", "id", id, "dir", source.dir)
+		return EXIT_NOT_FOUND
+	}
+
+	// Interrupting stops the job before its next stage
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	e := executor.New((*configs)[index])
+	e.WorkDir = *workDir
+	if err := e.Execute(ctx); err != nil {
+		slog.Error("Job failed", "id", id, "error", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// validate parses every config and prints each issue as
+// file:line:column: path: message
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	source := configFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	configs, code, err := source.parse()
+	if code != EXIT_OK {
+		var verr *parser.ValidationError
+		if !errors.As(err, &verr) {
+			// Failures to read the configs are reported the way load does
+			if err != nil {
+				slog.Error("Failed to parse config directory", "error", err, "dir", source.dir)
+			}
+			return code
+		}
+		for _, issue := range verr.Issues {
+			fmt.Fprintln(os.Stdout, issue.String())
+		}
+		return code
+	}
+
+	fmt.Fprintf(os.Stdout, "%d configs are valid\n", len(*configs))
+	return EXIT_OK
+}
+
+// list prints every config with its trigger and next fire time
+func list(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	source := configFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	configs, code := source.load()
+	if code != EXIT_OK {
+		return code
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDOMAIN\tNAME\tTRIGGER\tNEXT\tFILE")
+	for _, c := range *configs {
+		trigger, next := "manual", "-"
+		switch {
+		case c.DataSource.Trigger.Cron != "":
+			trigger = "cron " + c.DataSource.Trigger.Cron
+			// Validation already checked the expression
+			if schedule, err := cron.ParseStandard(c.DataSource.Trigger.Cron); err == nil {
+				next = schedule.Next(now).Format(time.RFC3339)
+				if c.DataSource.Trigger.RandomOffset {
+					next += " (+<60s)"
+				}
+			}
+		case len(c.DataSource.Trigger.DependsOn) > 0:
+			trigger = "after " + strings.Join(c.DataSource.Trigger.DependsOn, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Id, c.DataSource.Domain, c.DataSource.Name, trigger, next, c.File())
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("Failed to write configs", "error", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// render prints the effective configs after inheritance, profile overrides
+// and interpolation, with secrets redacted
+func render(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	source := configFlags(flags)
+	origins := flags.Bool("origins", false, "Comment every value with the file, defaults, profile or env it came from")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	configs, code := source.load()
+	if code != EXIT_OK {
+		return code
+	}
+
+	ids := flags.Args()
+	for _, id := range ids {
+		if !slices.ContainsFunc(*configs, func(c parser.Config) bool { return c.Id == id }) {
+			slog.Error("Config not found", "id", id, "dir", source.dir)
+			return EXIT_NOT_FOUND
+		}
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+
+	for _, config := range *configs {
+		if len(ids) > 0 && !slices.Contains(ids, config.Id) {
+			continue
+		}
+		render := config.Redacted
+		if *origins {
+			render = config.Annotated
+		}
+		node, err := render()
+		if err == nil {
+			err = encoder.Encode(node)
+		}
+		if err != nil {
+			slog.Error("Failed to render config", "error", err, "id", config.Id)
+			return EXIT_FAILURE
+		}
+	}
+	return EXIT_OK
+}
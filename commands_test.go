@@ -0,0 +1,307 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// capture runs a command and returns what it wrote to stdout with its exit
+// code
+func capture(t *testing.T, run func() int) (string, int) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	code := run()
+	w.Close()
+	return <-out, code
+}
+
+// writeConfigs writes config files to a temporary directory and returns it
+func writeConfigs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testConfigs are a defaults file and a config with a profile
+var testConfigs = map[string]string{
+	"_defaults.yaml": `connectors:
+  source:
+    type: filesystem
+    base_path: raw
+data_source:
+  source:
+    connector: source
+  destination:
+    connector: source
+`,
+	"users.yaml": `id: users
+data_source:
+  domain: test
+  name: users
+  trigger:
+    cron: "0 * * * *"
+  fields:
+    - label: id
+      data_type: string
+profiles:
+  prod:
+    connectors:
+      source:
+        base_path: /mnt/raw
+`,
+}
+
+func TestRender(t *testing.T) {
+	dir := writeConfigs(t, testConfigs)
+
+	tests := []struct {
+		name    string
+		args    []string
+		code    int
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "Effective Config",
+			args:    []string{"users"},
+			want:    []string{"base_path: raw\n", "cron: 0 * * * *\n"},
+			notWant: []string{"# from:"},
+		},
+		{
+			name: "Origins",
+			args: []string{"-origins", "-profile", "prod"},
+			want: []string{
+				"type: filesystem # from: defaults " + filepath.Join(dir, "_defaults.yaml") + ":3\n",
+				"base_path: /mnt/raw # from: profile prod " + filepath.Join(dir, "users.yaml") + ":14\n",
+				"cron: 0 * * * * # from: file " + filepath.Join(dir, "users.yaml") + ":6\n",
+			},
+		},
+		{name: "Unknown Id", args: []string{"orders"}, code: EXIT_NOT_FOUND},
+		{name: "Unknown Flag", args: []string{"-colour"}, code: EXIT_USAGE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := capture(t, func() int {
+				return render(append([]string{"-config-dir", dir}, tt.args...))
+			})
+			if code != tt.code {
+				t.Fatalf("render() = %d, want %d", code, tt.code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("render() output missing %q in\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("render() output has %q in\n%s", notWant, out)
+				}
+			}
+		})
+	}
+}
+
+// jobConfigs are configs of a job loading raw/*.csv into dest, relative to a
+// work directory
+var jobConfigs = map[string]string{
+	"load.yaml": `id: load
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+  destination:
+    type: filesystem
+    base_path: dest
+data_source:
+  domain: test
+  name: load
+  source:
+    connector: source
+  destination:
+    connector: destination
+  validate:
+    unique: [id]
+  fields:
+    - label: id
+      data_type: int
+`,
+}
+
+func TestRun(t *testing.T) {
+	dir := writeConfigs(t, jobConfigs)
+
+	tests := []struct {
+		name string
+		csv  string
+		args []string
+		code int
+	}{
+		{name: "Succeeded", csv: "id\n1\n2\n", args: []string{"load"}},
+		{name: "Job Failed", csv: "id\n1\n1\n", args: []string{"load"}, code: EXIT_FAILURE},
+		{name: "Missing Id", args: []string{}, code: EXIT_USAGE},
+		{name: "Too Many Ids", args: []string{"load", "users"}, code: EXIT_USAGE},
+		{name: "Unknown Id", args: []string{"users"}, code: EXIT_NOT_FOUND},
+		{name: "Unknown Flag", args: []string{"-colour", "load"}, code: EXIT_USAGE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := writeConfigs(t, map[string]string{"raw/source.csv": tt.csv})
+			if err := os.MkdirAll(filepath.Join(workDir, "dest"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			args := append([]string{"-config-dir", dir, "-work-dir", workDir}, tt.args...)
+			if code := run(args); code != tt.code {
+				t.Fatalf("run() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		invalid := writeConfigs(t, map[string]string{"load.yaml": "id: load\ncolour: red\n"})
+		if code := run([]string{"-config-dir", invalid, "load"}); code != EXIT_INVALID_CONFIG {
+			t.Fatalf("run() = %d, want %d", code, EXIT_INVALID_CONFIG)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		args  []string
+		code  int
+		want  string
+	}{
+		{name: "Valid", files: testConfigs, want: "1 configs are valid\n"},
+		{
+			name: "Issues",
+			files: map[string]string{
+				"_defaults.yaml": testConfigs["_defaults.yaml"],
+				"users.yaml":     testConfigs["users.yaml"] + "colour: red\n",
+			},
+			code: EXIT_INVALID_CONFIG,
+			want: "users.yaml:15:1: colour: unknown key",
+		},
+		{name: "Unknown Profile", files: testConfigs, args: []string{"-profile", "test"}, code: EXIT_INVALID_CONFIG},
+		{name: "Unknown Flag", files: testConfigs, args: []string{"-colour"}, code: EXIT_USAGE},
+		{name: "Empty Directory", args: []string{"-config-dir", ""}, code: EXIT_USAGE},
+		{name: "Missing Directory", args: []string{"-config-dir", filepath.Join(t.TempDir(), "missing")}, code: EXIT_INVALID_CONFIG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigs(t, tt.files)
+			out, code := capture(t, func() int {
+				return validate(append([]string{"-config-dir", dir}, tt.args...))
+			})
+			if code != tt.code {
+				t.Fatalf("validate() = %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("validate() output missing %q in\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	files := map[string]string{
+		"_defaults.yaml": testConfigs["_defaults.yaml"],
+		"users.yaml":     testConfigs["users.yaml"],
+		"orders.yaml": `id: orders
+data_source:
+  domain: test
+  name: orders
+  trigger:
+    depends_on: [users]
+  fields:
+    - label: id
+      data_type: string
+`,
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		args []string
+		code int
+		want []string
+	}{
+		{
+			name: "Triggers",
+			dir:  writeConfigs(t, files),
+			want: []string{"ID ", "users ", "cron 0 * * * *", "orders ", "after users"},
+		},
+		{name: "Missing Directory", dir: filepath.Join(t.TempDir(), "missing"), code: EXIT_INVALID_CONFIG},
+		{name: "Empty Directory", dir: "", code: EXIT_USAGE},
+		{name: "Unknown Flag", dir: writeConfigs(t, files), args: []string{"-colour"}, code: EXIT_USAGE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := capture(t, func() int {
+				return list(append([]string{"-config-dir", tt.dir}, tt.args...))
+			})
+			if code != tt.code {
+				t.Fatalf("list() = %d, want %d", code, tt.code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("list() output missing %q in\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "No Command", args: []string{}, code: EXIT_USAGE},
+		{name: "Help", args: []string{"help"}, code: EXIT_USAGE},
+		{name: "Help Flag", args: []string{"-h"}, code: EXIT_USAGE},
+		{name: "Unknown Command", args: []string{"start"}, code: EXIT_USAGE},
+		{name: "Flags Start Daemon", args: []string{"-colour"}, code: EXIT_USAGE},
+		{name: "Command", args: []string{"schema"}, code: EXIT_OK},
+		{name: "Command Flags", args: []string{"schema", "-colour"}, code: EXIT_USAGE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code := capture(t, func() int { return dispatch(tt.args) })
+			if code != tt.code {
+				t.Fatalf("dispatch() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// schema writes the JSON Schema of the config format to stdout or a file
+func schema(args []string) int {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	output := flags.String("o", "", "File to write the schema to instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	data, err := parser.JSONSchema()
+	if err != nil {
+		slog.Error("Failed to generate schema", "error", err)
+		return EXIT_FAILURE
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return EXIT_OK
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		slog.Error("Failed to write schema", "error", err, "file", *output)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// configCommand runs the config maintenance subcommands
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: mdf config migrate [-config-dir dir] [-dry-run]")
+		fmt.Fprintln(os.Stderr, "       mdf config render [-config-dir dir] [-profile name] [-origins] [id ...]")
+		return EXIT_USAGE
+	}
+
+	switch args[0] {
+	case "migrate":
+		return migrate(args[1:])
+	case "render":
+		return render(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config subcommand %q\n", args[0])
+		return EXIT_USAGE
+	}
+}
+
+// migrate rewrites the config files of a local directory to the current
+// schema version and prints the diff of every changed file
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	configDir := flags.String("config-dir", "configs", "Local directory containing configuration files")
+	dryRun := flags.Bool("dry-run", false, "Print the changes without writing them")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	failed := false
+	err := filepath.WalkDir(*configDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			return nil
+		}
+
+		before, after, err := parser.MigrateFile(path)
+		if err != nil {
+			slog.Error("Failed to migrate config file", "error", err, "file", path)
+			failed = true
+			return nil
+		}
+		diff := parser.Diff(path, before, after)
+		if diff == "" {
+			return nil
+		}
+
+		fmt.Print(diff)
+		if *dryRun {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, after, info.Mode().Perm())
+	})
+	if err != nil {
+		slog.Error("Failed to migrate config directory", "error", err, "dir", *configDir)
+		return EXIT_FAILURE
+	}
+	if failed {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
+	"github.com/andrew-a-hale/mdf/internal/triggerer"
+)
+
+// daemon runs the triggerer and scheduler until interrupted, reloading the
+// configs on SIGHUP
+func daemon(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	source := configFlags(flags)
+	settings := settingsFlags(flags)
+	settingsFile := settingsFileFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if err := settings.load(flags, *settingsFile); err != nil {
+		slog.Error("Failed to load daemon settings", "error", err, "file", *settingsFile)
+		return EXIT_USAGE
+	}
+
+	// Parse configuration directory
+	slog.Info("Using config directory", "dir", source.dir, "profile", source.profile)
+	config, code := source.load()
+	if code != EXIT_OK {
+		return code
+	}
+
+	policy := scheduler.FailurePolicy(settings.FailurePolicy)
+	if policy != scheduler.REQUEUE && policy != scheduler.DEAD_LETTER {
+		slog.Error("Unknown failure policy", "policy", policy, "flag", "-failure-policy")
+		return EXIT_USAGE
+	}
+
+	// Initialize the job queue
+	var broker scheduler.Broker
+	switch settings.Broker.Kind {
+	case "memory":
+		broker = scheduler.NewMemoryBroker(settings.Broker.QueueSize)
+	case "rabbitmq":
+		// Wait for the broker to come up, unless interrupted
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		rmq, err := scheduler.NewRabbitMQBroker(ctx, settings.Broker.RabbitMQOptions)
+		stop()
+		if err != nil {
+			slog.Error("Failed to connect to broker", "error", err)
+			return EXIT_FAILURE
+		}
+		broker = rmq
+	default:
+		slog.Error("Unknown broker", "broker", settings.Broker.Kind, "flag", "-broker")
+		return EXIT_USAGE
+	}
+	defer broker.Close()
+	slog.Info("Using broker", "broker", settings.Broker.Kind)
+
+	// Initialize and start the Triggerer
+	triggerer := triggerer.New(config, broker)
+	err := triggerer.Start()
+	if err != nil {
+		slog.Error("Failed to start triggerer", "error", err)
+		return EXIT_FAILURE
+	}
+
+	slog.Info("Triggerer is running in background...")
+
+	// Initialize and start the Scheduler
+	scheduler := scheduler.New(broker, func(id string) (parser.Config, bool) {
+		return triggerer.Configs().Lookup(id)
+	}, scheduler.Options{
+		Workers:       settings.Workers,
+		FailurePolicy: policy,
+		WorkDir:       settings.WorkDir,
+		OnComplete: func(job scheduler.Job, err error) {
+			if err := triggerer.Complete(job, err); err != nil {
+				slog.Error("Failed to trigger downstream jobs", "config_id", job.ConfigId, "error", err)
+			}
+		},
+	})
+	err = scheduler.Start()
+	if err != nil {
+		slog.Error("Failed to start scheduler", "error", err)
+		return EXIT_FAILURE
+	}
+
+	slog.Info("Scheduler is running in background, waiting for jobs")
+
+	// Setup signal handling for graceful shutdown and reloads
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Keepalive ticker for logging that process is still running
+	keepaliveTicker := time.NewTicker(30 * time.Minute)
+	defer keepaliveTicker.Stop()
+
+	// Reload ticker for picking up config changes without a signal
+	var reloadCh <-chan time.Time
+	if settings.ReloadInterval > 0 {
+		reloadTicker := time.NewTicker(settings.ReloadInterval)
+		defer reloadTicker.Stop()
+		reloadCh = reloadTicker.C
+	}
+
+	// Keep process alive until interrupted
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				slog.Info("Received signal, reloading configs", "signal", sig.String())
+				reload(source, triggerer)
+				continue
+			}
+			slog.Info("Received signal, shutting down",
+				"signal", sig.String(),
+				"timeout", settings.ShutdownTimeout.String())
+			return shutdown(sigCh, settings.ShutdownTimeout, triggerer, scheduler)
+		case <-reloadCh:
+			reload(source, triggerer)
+		case <-keepaliveTicker.C:
+			slog.Info("Triggerer is still running")
+		}
+	}
+}
+
+// shutdown stops firing triggers and waits up to timeout for running jobs to
+// finish. Jobs still running at the deadline, or when a second signal
+// arrives, are cancelled and EXIT_NOT_DRAINED is returned.
+func shutdown(sigCh <-chan os.Signal, timeout time.Duration, t *triggerer.CronTriggerer, s scheduler.Scheduler) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				if sig == syscall.SIGHUP {
+					continue
+				}
+				slog.Warn("Received second signal, cancelling running jobs", "signal", sig.String())
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := t.Stop(ctx); err != nil {
+		slog.Warn("Triggerer did not stop in time", "error", err)
+	}
+	slog.Info("Triggerer stopped, waiting for running jobs")
+
+	if err := s.Stop(ctx); err != nil {
+		slog.Error("Shutdown did not drain", "error", err)
+		return EXIT_NOT_DRAINED
+	}
+	slog.Info("Running jobs finished, exiting")
+	return EXIT_OK
+}
+
+// reload parses the config directory and hands it to the triggerer. A broken
+// config set is rejected and the running configs are kept.
+func reload(source *configSource, t *triggerer.CronTriggerer) {
+	configs, err := parser.ParseConfigRemoteDirectory(source.dir, parser.WithProfile(source.profile))
+	if err != nil {
+		var verr *parser.ValidationError
+		if errors.As(err, &verr) {
+			for _, issue := range verr.Issues {
+				slog.Error("Rejected config", "dir", source.dir, "issue", issue.String())
+			}
+		}
+		slog.Error("Rejected config reload, keeping running configs", "error", err, "dir", source.dir)
+		return
+	}
+
+	err = t.Reload(configs)
+	if err != nil {
+		slog.Error("Rejected config reload, keeping running configs", "error", err, "dir", source.dir)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
+)
+
+// dlq runs the dead letter queue subcommands
+func dlq(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: mdf dlq list [-limit n]")
+		fmt.Fprintln(os.Stderr, "       mdf dlq retry [-all] [run_id|config_id ...]")
+		fmt.Fprintln(os.Stderr, "       mdf dlq purge [-all] [run_id|config_id ...]")
+		return EXIT_USAGE
+	}
+
+	switch args[0] {
+	case "list":
+		return dlqList(args[1:])
+	case "retry":
+		return dlqRetry(args[1:])
+	case "purge":
+		return dlqPurge(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dlq subcommand %q\n", args[0])
+		return EXIT_USAGE
+	}
+}
+
+// dlqFlags registers the broker flags of a dlq subcommand
+func dlqFlags(name string) (*flag.FlagSet, *daemonSettings, *string) {
+	flags := flag.NewFlagSet("dlq "+name, flag.ContinueOnError)
+	settings := &daemonSettings{}
+	brokerFlags(flags, &settings.Broker)
+	return flags, settings, settingsFileFlag(flags)
+}
+
+// dlqOptions loads the settings of the rabbitmq broker holding the dead
+// letters
+func dlqOptions(flags *flag.FlagSet, settings *daemonSettings, file string) (scheduler.RabbitMQOptions, int) {
+	if err := settings.load(flags, file); err != nil {
+		slog.Error("Failed to load daemon settings", "error", err, "file", file)
+		return scheduler.RabbitMQOptions{}, EXIT_USAGE
+	}
+	if settings.Broker.Kind != "rabbitmq" {
+		slog.Error("Dead letters are only kept by the rabbitmq broker", "broker", settings.Broker.Kind, "flag", "-broker")
+		return scheduler.RabbitMQOptions{}, EXIT_USAGE
+	}
+	return settings.Broker.RabbitMQOptions, EXIT_OK
+}
+
+// matchJobs selects the dead letters named by run or config id. Without ids
+// it selects every job when all is set, and reports false otherwise.
+func matchJobs(ids []string, all bool) (func(scheduler.Job) bool, bool) {
+	if len(ids) == 0 {
+		return func(scheduler.Job) bool { return true }, all
+	}
+	return func(job scheduler.Job) bool {
+		return slices.Contains(ids, job.RunId) || slices.Contains(ids, job.ConfigId)
+	}, true
+}
+
+// dlqList prints the dead lettered jobs, leaving them in the queue
+func dlqList(args []string) int {
+	flags, settings, file := dlqFlags("list")
+	limit := flags.Int("limit", 100, "Maximum number of dead letters to print")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if *limit <= 0 {
+		fmt.Fprintln(os.Stderr, "-limit must be positive")
+		return EXIT_USAGE
+	}
+
+	opts, code := dlqOptions(flags, settings, *file)
+	if code != EXIT_OK {
+		return code
+	}
+	queue, err := scheduler.OpenDeadLetterQueue(opts)
+	if err != nil {
+		slog.Error("Failed to open dead letter queue", "error", err)
+		return EXIT_FAILURE
+	}
+	defer queue.Close()
+
+	letters, err := queue.List(*limit)
+	if err != nil {
+		slog.Error("Failed to list dead letters", "error", err)
+		return EXIT_FAILURE
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN_ID\tCONFIG_ID\tLOGICAL_TIME\tTRIGGER\tATTEMPT\tREASON\tDEAD_LETTERED")
+	for _, l := range letters {
+		deadLettered := "-"
+		if !l.Time.IsZero() {
+			deadLettered = l.Time.Format(time.RFC3339)
+		}
+		if l.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\tinvalid: %v\t%s\n", l.MessageId, l.Err, deadLettered)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			l.Job.RunId,
+			l.Job.ConfigId,
+			l.Job.LogicalTime.Format(time.RFC3339),
+			l.Job.Trigger,
+			l.Job.Attempt,
+			l.Reason,
+			deadLettered)
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("Failed to write dead letters", "error", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// dlqRetry moves dead lettered jobs back to the job queue
+func dlqRetry(args []string) int {
+	flags, settings, file := dlqFlags("retry")
+	all := flags.Bool("all", false, "Retry every dead lettered job")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	match, ok := matchJobs(flags.Args(), *all)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "usage: mdf dlq retry [-all] [run_id|config_id ...]")
+		return EXIT_USAGE
+	}
+
+	opts, code := dlqOptions(flags, settings, *file)
+	if code != EXIT_OK {
+		return code
+	}
+	queue, err := scheduler.OpenDeadLetterQueue(opts)
+	if err != nil {
+		slog.Error("Failed to open dead letter queue", "error", err)
+		return EXIT_FAILURE
+	}
+	defer queue.Close()
+
+	retried, err := queue.Retry(context.Background(), match)
+	if err != nil {
+		slog.Error("Failed to retry dead letters", "retried", retried, "error", err)
+		return EXIT_FAILURE
+	}
+	fmt.Printf("%d jobs retried\n", retried)
+	return EXIT_OK
+}
+
+// dlqPurge deletes dead lettered jobs
+func dlqPurge(args []string) int {
+	flags, settings, file := dlqFlags("purge")
+	all := flags.Bool("all", false, "Purge every dead letter, including messages that are not valid jobs")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	match, ok := matchJobs(flags.Args(), *all)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "usage: mdf dlq purge [-all] [run_id|config_id ...]")
+		return EXIT_USAGE
+	}
+	if flags.NArg() == 0 {
+		match = nil
+	}
+
+	opts, code := dlqOptions(flags, settings, *file)
+	if code != EXIT_OK {
+		return code
+	}
+	queue, err := scheduler.OpenDeadLetterQueue(opts)
+	if err != nil {
+		slog.Error("Failed to open dead letter queue", "error", err)
+		return EXIT_FAILURE
+	}
+	defer queue.Close()
+
+	purged, err := queue.Purge(match)
+	if err != nil {
+		slog.Error("Failed to purge dead letters", "purged", purged, "error", err)
+		return EXIT_FAILURE
+	}
+	fmt.Printf("%d dead letters purged\n", purged)
+	return EXIT_OK
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
+)
+
+func TestDlq(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "settings.yaml")
+	if err := os.WriteFile(invalid, []byte("broker: [rabbitmq]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on port 1, so opening the queue fails fast
+	rabbitmq := []string{"-broker", "rabbitmq", "-broker-url", "amqp://127.0.0.1:1/"}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "No Subcommand", args: []string{}, code: EXIT_USAGE},
+		{name: "Unknown Subcommand", args: []string{"show"}, code: EXIT_USAGE},
+		{name: "Unknown Flag", args: []string{"list", "-colour"}, code: EXIT_USAGE},
+		{name: "Zero Limit", args: append([]string{"list", "-limit", "0"}, rabbitmq...), code: EXIT_USAGE},
+		{name: "Memory Broker", args: []string{"list"}, code: EXIT_USAGE},
+		{name: "Invalid Settings File", args: []string{"list", "-settings", invalid}, code: EXIT_USAGE},
+		{name: "Retry Without Ids", args: append([]string{"retry"}, rabbitmq...), code: EXIT_USAGE},
+		{name: "Purge Without Ids", args: append([]string{"purge"}, rabbitmq...), code: EXIT_USAGE},
+		{name: "No Dead Letter Exchange", args: append([]string{"list", "-broker-dead-letter-exchange", ""}, rabbitmq...), code: EXIT_FAILURE},
+		{name: "List Unreachable", args: append([]string{"list"}, rabbitmq...), code: EXIT_FAILURE},
+		{name: "Retry Unreachable", args: append(append([]string{"retry"}, rabbitmq...), "run-1"), code: EXIT_FAILURE},
+		{name: "Purge All Unreachable", args: append(append([]string{"purge"}, rabbitmq...), "-all"), code: EXIT_FAILURE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := dlq(tt.args); code != tt.code {
+				t.Fatalf("dlq() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestMatchJobs(t *testing.T) {
+	job := scheduler.Job{RunId: "run-1", ConfigId: "users"}
+
+	tests := []struct {
+		name  string
+		ids   []string
+		all   bool
+		ok    bool
+		match bool
+	}{
+		{name: "Nothing Selected", ok: false, match: true},
+		{name: "All", all: true, ok: true, match: true},
+		{name: "Run Id", ids: []string{"run-1"}, ok: true, match: true},
+		{name: "Config Id", ids: []string{"orders", "users"}, ok: true, match: true},
+		{name: "Other Ids", ids: []string{"run-2", "orders"}, ok: true, match: false},
+		{name: "Ids Narrow All", ids: []string{"orders"}, all: true, ok: true, match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := matchJobs(tt.ids, tt.all)
+			if ok != tt.ok {
+				t.Fatalf("matchJobs() ok = %v, want %v", ok, tt.ok)
+			}
+			if got := match(job); got != tt.match {
+				t.Errorf("match() = %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
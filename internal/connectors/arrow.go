@@ -0,0 +1,320 @@
+package connectors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// decimalType is the type of a decimal field without a precision and scale,
+// DuckDB's DECIMAL defaults to 18 digits with 3 after the point
+var decimalType = &arrow.Decimal128Type{Precision: 18, Scale: 3}
+
+// DataType returns the arrow type of a field data type, it matches the type
+// DuckDB exports for the same SQL type
+func DataType(dataType string) (arrow.DataType, error) {
+	if precision, scale, ok := parser.ParseDecimal(dataType); ok {
+		return &arrow.Decimal128Type{Precision: precision, Scale: scale}, nil
+	}
+	switch strings.ToLower(dataType) {
+	case "bigint":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "bool", "boolean":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "date":
+		return arrow.FixedWidthTypes.Date32, nil
+	case "decimal":
+		return decimalType, nil
+	case "double":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "float":
+		return arrow.PrimitiveTypes.Float32, nil
+	case "int", "integer":
+		return arrow.PrimitiveTypes.Int32, nil
+	case "string", "varchar":
+		return arrow.BinaryTypes.String, nil
+	case "timestamp":
+		return &arrow.TimestampType{Unit: arrow.Microsecond}, nil
+	default:
+		return nil, fmt.Errorf("unknown data type %q, must be one of: %v or decimal(p,s)", dataType, parser.DataTypes)
+	}
+}
+
+// Schema returns the arrow schema of the declared fields, or nil when no
+// fields are declared and the schema is inferred from the source
+func Schema(fields []parser.FieldConfig) (*arrow.Schema, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	arrowFields := make([]arrow.Field, 0, len(fields))
+	for _, field := range fields {
+		dataType, err := DataType(field.DataType)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", field.Label, err)
+		}
+		arrowFields = append(arrowFields, arrow.Field{Name: field.Label, Type: dataType, Nullable: true})
+	}
+	return arrow.NewSchema(arrowFields, nil), nil
+}
+
+// SameSchema reports whether two schemas have the same field names and types
+func SameSchema(a, b *arrow.Schema) bool {
+	if a.NumFields() != b.NumFields() {
+		return false
+	}
+	for i := range a.NumFields() {
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Name != fb.Name || !arrow.TypeEqual(fa.Type, fb.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRecord converts rows to a record of the given schema. Missing and nil
+// values are null, other values are converted to the field type.
+func NewRecord(schema *arrow.Schema, rows []map[string]any) (arrow.Record, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("no fields are declared to convert rows to")
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	b.Reserve(len(rows))
+
+	for i, field := range schema.Fields() {
+		fb := b.Field(i)
+		for row, values := range rows {
+			if err := appendValue(fb, values[field.Name]); err != nil {
+				return nil, fmt.Errorf("field '%s' (row %d): %w", field.Name, row, err)
+			}
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// appendValue converts a value to the type of a builder and appends it
+func appendValue(b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.Int32Builder:
+		n, err := toInt(v, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(int32(n))
+	case *array.Int64Builder:
+		n, err := toInt(v, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Float32Builder:
+		f, err := toFloat(v, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(f))
+	case *array.Float64Builder:
+		f, err := toFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.BooleanBuilder:
+		switch v := v.(type) {
+		case bool:
+			b.Append(v)
+		case string:
+			t, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			b.Append(t)
+		default:
+			return fmt.Errorf("cannot convert %T to boolean", v)
+		}
+	case *array.StringBuilder:
+		if s, ok := v.(string); ok {
+			b.Append(s)
+		} else {
+			b.Append(fmt.Sprint(v))
+		}
+	case *array.Date32Builder:
+		t, err := toTime(v, time.DateOnly)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		t, err := toTime(v, time.RFC3339Nano, time.DateTime)
+		if err != nil {
+			return err
+		}
+		ts, err := arrow.TimestampFromTime(t, arrow.Microsecond)
+		if err != nil {
+			return err
+		}
+		b.Append(ts)
+	case *array.Decimal128Builder:
+		dt := b.Type().(*arrow.Decimal128Type)
+		var n decimal128.Num
+		var err error
+		switch v := v.(type) {
+		case duckdb.Decimal:
+			// Exact decimals are rescaled, failing rather than rounding
+			n, err = decimal128.FromBigInt(v.Value).Rescale(int32(v.Scale), dt.Scale)
+			if err == nil && !n.FitsInPrecision(dt.Precision) {
+				err = fmt.Errorf("%s overflows decimal(%d,%d)", v.String(), dt.Precision, dt.Scale)
+			}
+		case float64:
+			n, err = decimal128.FromFloat64(v, dt.Precision, dt.Scale)
+		case float32:
+			n, err = decimal128.FromFloat64(float64(v), dt.Precision, dt.Scale)
+		default:
+			n, err = decimal128.FromString(fmt.Sprint(v), dt.Precision, dt.Scale)
+		}
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	default:
+		return fmt.Errorf("unsupported type %s", b.Type())
+	}
+	return nil
+}
+
+// toInt converts an integer, a whole float or a string to an integer of the
+// given size
+func toInt(v any, bits int) (int64, error) {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint8:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		n = int64(v)
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, bits)
+	default:
+		return 0, fmt.Errorf("cannot convert %T to an integer", v)
+	}
+	if bits == 32 && int64(int32(n)) != n {
+		return 0, fmt.Errorf("%d overflows a 32 bit integer", n)
+	}
+	return n, nil
+}
+
+// toFloat converts a number or a string to a float of the given size
+func toFloat(v any, bits int) (float64, error) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), bits)
+	default:
+		n, err := toInt(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %T to a float", v)
+		}
+		return float64(n), nil
+	}
+}
+
+// toTime converts a time or a string in one of the layouts to a time
+func toTime(v any, layouts ...string) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		var err error
+		for _, layout := range layouts {
+			var t time.Time
+			if t, err = time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, err
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to a time", v)
+	}
+}
+
+// Rows converts a record to rows, values have the Go type the DuckDB driver
+// scans the same SQL type into
+func Rows(rec arrow.Record) []map[string]any {
+	rows := make([]map[string]any, rec.NumRows())
+	for i := range rows {
+		rows[i] = make(map[string]any, rec.NumCols())
+	}
+	for c, col := range rec.Columns() {
+		name := rec.ColumnName(c)
+		for i := range rows {
+			rows[i][name] = Value(col, i)
+		}
+	}
+	return rows
+}
+
+// Value returns the value of a row of an arrow array, or nil when it is null
+func Value(col arrow.Array, i int) any {
+	if col.IsNull(i) {
+		return nil
+	}
+	switch col := col.(type) {
+	case *array.Int32:
+		return col.Value(i)
+	case *array.Int64:
+		return col.Value(i)
+	case *array.Float32:
+		return col.Value(i)
+	case *array.Float64:
+		return col.Value(i)
+	case *array.Boolean:
+		return col.Value(i)
+	case *array.String:
+		// The value points into the record, which may be released
+		return strings.Clone(col.Value(i))
+	case *array.Date32:
+		return col.Value(i).ToTime()
+	case *array.Timestamp:
+		unit := col.DataType().(*arrow.TimestampType).Unit
+		return col.Value(i).ToTime(unit)
+	case *array.Decimal128:
+		dt := col.DataType().(*arrow.Decimal128Type)
+		return duckdb.Decimal{Width: uint8(dt.Precision), Scale: uint8(dt.Scale), Value: col.Value(i).BigInt()}
+	default:
+		return col.GetOneForMarshal(i)
+	}
+}
@@ -0,0 +1,78 @@
+package connectors
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/marcboeker/go-duckdb"
+)
+
+func TestNewRecord(t *testing.T) {
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "total", DataType: "bigint"},
+		{Label: "price", DataType: "decimal"},
+		{Label: "amount", DataType: "decimal(38,2)"},
+		{Label: "active", DataType: "bool"},
+		{Label: "name", DataType: "string"},
+		{Label: "born", DataType: "date"},
+		{Label: "seen", DataType: "timestamp"},
+	}
+	schema, err := Schema(fields)
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+
+	seen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	// More digits than a float64 holds
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	rec, err := NewRecord(schema, []map[string]any{
+		{"id": "1", "total": 2, "price": 1.25, "amount": duckdb.Decimal{Width: 38, Scale: 3, Value: amount}, "active": true, "name": "a", "born": "2000-01-31", "seen": seen},
+		{"id": 2},
+	})
+	if err != nil {
+		t.Fatalf("NewRecord() error = %v", err)
+	}
+	defer rec.Release()
+
+	rows := Rows(rec)
+	want := map[string]any{
+		"id":     int32(1),
+		"total":  int64(2),
+		"active": true,
+		"name":   "a",
+		"born":   time.Date(2000, 1, 31, 0, 0, 0, 0, time.UTC),
+		"seen":   seen,
+	}
+	for field, value := range want {
+		if got := rows[0][field]; got != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", field, got, got, value, value)
+		}
+	}
+
+	// Decimals keep every digit of their type
+	for field, value := range map[string]string{"price": "1.25", "amount": "123456789012345678901234567.89"} {
+		d, ok := rows[0][field].(duckdb.Decimal)
+		if !ok || d.String() != value {
+			t.Errorf("%s = %v (%T), want %s", field, rows[0][field], rows[0][field], value)
+		}
+	}
+	if _, err := NewRecord(schema, []map[string]any{{"amount": duckdb.Decimal{Width: 38, Scale: 3, Value: big.NewInt(1001)}}}); err == nil {
+		t.Error("NewRecord() with a decimal that loses digits should return error")
+	}
+
+	// Missing values are null
+	if rows[1]["id"] != int32(2) || rows[1]["name"] != nil {
+		t.Errorf("Second row = %v, want id 2 and a null name", rows[1])
+	}
+
+	// Values that do not convert to the field type are an error
+	if _, err := NewRecord(schema, []map[string]any{{"id": "one"}}); err == nil {
+		t.Error("NewRecord() with an invalid int should return error")
+	}
+	if _, err := Schema([]parser.FieldConfig{{Label: "id", DataType: "uuid"}}); err == nil {
+		t.Error("Schema() with an unknown data type should return error")
+	}
+}
@@ -0,0 +1,204 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// BATCH_SIZE is the maximum number of rows in a batch
+const BATCH_SIZE = 10000
+
+// BatchReader reads a resource one arrow record batch at a time
+type BatchReader interface {
+	// Next returns the next batch of at most BATCH_SIZE rows, or io.EOF once
+	// every row has been read. The caller releases the batch.
+	Next(ctx context.Context) (arrow.Record, error)
+
+	// Close releases the reader, it may be called before the last batch
+	Close() error
+}
+
+// DuplicateFinder is implemented by batch readers that can find repeated
+// values without holding the values read so far, such as a DuckDB query that
+// spills to disk
+type DuplicateFinder interface {
+	// FirstDuplicates returns the row of the first repeated value of each
+	// field, keyed by field, with rows numbered in the order they are read.
+	// Fields without repeated values or missing from the resource are left
+	// out. Null values repeat each other.
+	FirstDuplicates(ctx context.Context, fields []string) (map[string]int, error)
+}
+
+// BatchWriter writes a resource one arrow record batch at a time. Written
+// batches are not visible to readers of the resource until Commit, Abort
+// discards them.
+type BatchWriter interface {
+	// Write writes a batch, it retains the batch for as long as it needs it
+	Write(ctx context.Context, batch arrow.Record) error
+
+	// Commit makes the written batches visible
+	Commit(ctx context.Context) error
+
+	// Abort discards the written batches, it is a no-op after Commit
+	Abort() error
+}
+
+// BatchConnector streams a resource in bounded batches, so memory use does
+// not grow with the size of the resource
+type BatchConnector interface {
+	// ReadBatches opens a reader over a resource
+	ReadBatches(ctx context.Context) (BatchReader, error)
+
+	// WriteBatches opens a writer to a resource
+	WriteBatches(ctx context.Context) (BatchWriter, error)
+
+	// Close closes the connector
+	Close() error
+}
+
+// ErrNotLoadable is returned by a Loader that cannot load a source directly
+var ErrNotLoadable = errors.New("source cannot be loaded directly")
+
+// Loader is implemented by connectors that can load a source without
+// streaming its batches through the executor, such as a DuckDB query between
+// two filesystem connectors
+type Loader interface {
+	// LoadFrom validates a source against the rules and writes it to the
+	// connector, returning the number of records loaded. Validation failures
+	// are *validator.Error, as reported when streaming batches of BATCH_SIZE
+	// rows. It returns ErrNotLoadable when it cannot load the source.
+	LoadFrom(ctx context.Context, source Connector, rules parser.ValidationConfig) (int, error)
+}
+
+// Batches returns the streaming interface of a connector. Connectors that only
+// implement Connector are adapted: they read a whole resource before it is
+// converted to batches of the schema, and write every batch at once on Commit.
+func Batches(c Connector, schema *arrow.Schema) BatchConnector {
+	if bc, ok := c.(BatchConnector); ok {
+		return bc
+	}
+	return adapter{Connector: c, schema: schema}
+}
+
+// ReadAll reads the remaining batches of a reader into one dataset
+func ReadAll(ctx context.Context, r BatchReader) ([]map[string]any, error) {
+	data := []map[string]any{}
+	for {
+		batch, err := r.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, Rows(batch)...)
+		batch.Release()
+	}
+}
+
+// WriteAll converts a dataset to batches of the schema, writes them and
+// commits them
+func WriteAll(ctx context.Context, w BatchWriter, schema *arrow.Schema, data []map[string]any) error {
+	for start := 0; start < len(data); start += BATCH_SIZE {
+		end := min(start+BATCH_SIZE, len(data))
+		batch, err := NewRecord(schema, data[start:end])
+		if err != nil {
+			w.Abort()
+			return err
+		}
+		err = w.Write(ctx, batch)
+		batch.Release()
+		if err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if err := w.Commit(ctx); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+// adapter streams a connector that reads and writes whole datasets
+type adapter struct {
+	Connector
+	schema *arrow.Schema
+}
+
+// ReadBatches reads the whole resource and splits it into batches
+func (a adapter) ReadBatches(ctx context.Context) (BatchReader, error) {
+	if a.schema == nil {
+		return nil, fmt.Errorf("connector %T can only read batches of declared fields", a.Connector)
+	}
+	data, err := a.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sliceReader{schema: a.schema, data: data}, nil
+}
+
+// WriteBatches buffers the written batches until Commit
+func (a adapter) WriteBatches(ctx context.Context) (BatchWriter, error) {
+	return &bufferWriter{c: a.Connector}, nil
+}
+
+// sliceReader converts a dataset to batches
+type sliceReader struct {
+	schema *arrow.Schema
+	data   []map[string]any
+}
+
+func (r *sliceReader) Next(ctx context.Context) (arrow.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.data) == 0 {
+		return nil, io.EOF
+	}
+	n := min(BATCH_SIZE, len(r.data))
+	batch, err := NewRecord(r.schema, r.data[:n])
+	if err != nil {
+		return nil, err
+	}
+	r.data = r.data[n:]
+	return batch, nil
+}
+
+func (r *sliceReader) Close() error {
+	r.data = nil
+	return nil
+}
+
+// bufferWriter collects batches and writes them as one dataset on Commit
+type bufferWriter struct {
+	c    Connector
+	data []map[string]any
+}
+
+func (w *bufferWriter) Write(ctx context.Context, batch arrow.Record) error {
+	w.data = append(w.data, Rows(batch)...)
+	return nil
+}
+
+func (w *bufferWriter) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data := w.data
+	w.data = nil
+	if data == nil {
+		data = []map[string]any{}
+	}
+	return w.c.Write(ctx, data)
+}
+
+func (w *bufferWriter) Abort() error {
+	w.data = nil
+	return nil
+}
@@ -0,0 +1,82 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// rowsConnector is a connector that reads and writes whole datasets
+type rowsConnector struct {
+	data   []map[string]any
+	writes [][]map[string]any
+}
+
+func (c *rowsConnector) Read(context.Context) ([]map[string]any, error) { return c.data, nil }
+func (c *rowsConnector) Close() error                                   { return nil }
+func (c *rowsConnector) Write(ctx context.Context, data []map[string]any) error {
+	c.writes = append(c.writes, data)
+	return nil
+}
+
+func TestBatches(t *testing.T) {
+	ctx := context.Background()
+	schema, err := Schema([]parser.FieldConfig{{Label: "id", DataType: "int"}})
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+	c := &rowsConnector{}
+	for i := range BATCH_SIZE*2 + 1 {
+		c.data = append(c.data, map[string]any{"id": i})
+	}
+
+	reader, err := Batches(c, schema).ReadBatches(ctx)
+	if err != nil {
+		t.Fatalf("ReadBatches() error = %v", err)
+	}
+	var sizes []int
+	for {
+		batch, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		sizes = append(sizes, int(batch.NumRows()))
+		batch.Release()
+	}
+	if len(sizes) != 3 || sizes[0] != BATCH_SIZE || sizes[2] != 1 {
+		t.Errorf("Batch sizes = %v, want [%d %d 1]", sizes, BATCH_SIZE, BATCH_SIZE)
+	}
+
+	// Batches are written at once on commit
+	writer, err := Batches(c, schema).WriteBatches(ctx)
+	if err != nil {
+		t.Fatalf("WriteBatches() error = %v", err)
+	}
+	if err := WriteAll(ctx, writer, schema, c.data); err != nil {
+		t.Fatalf("WriteAll() error = %v", err)
+	}
+	if len(c.writes) != 1 || len(c.writes[0]) != len(c.data) {
+		t.Errorf("Got %d writes, want one write of %d rows", len(c.writes), len(c.data))
+	}
+
+	// Aborted batches are never written
+	writer, _ = Batches(c, schema).WriteBatches(ctx)
+	batch, err := NewRecord(schema, c.data[:1])
+	if err != nil {
+		t.Fatalf("NewRecord() error = %v", err)
+	}
+	defer batch.Release()
+	if err := writer.Write(ctx, batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	writer.Abort()
+	if len(c.writes) != 1 {
+		t.Errorf("Got %d writes after abort, want 1", len(c.writes))
+	}
+}
@@ -1,17 +1,31 @@
 package connectors
 
+import "context"
+
 const (
 	FILESYSTEM = "filesystem"
 )
 
-// Connector defines the interface for data connectors
+// Connector defines the interface for data connectors that read and write
+// whole datasets. Connectors that can stream also implement BatchConnector,
+// the rest are adapted by Batches.
 type Connector interface {
-	// Read reads data from a resource
-	Read() ([]map[string]any, error)
+	// Read reads data from a resource, it stops when ctx is done
+	Read(ctx context.Context) ([]map[string]any, error)
 
-	// Write writes data to a resource
-	Write([]map[string]any) error
+	// Write writes data to a resource, nothing is written when ctx is done
+	// before it finishes
+	Write(ctx context.Context, data []map[string]any) error
 
 	// Close closes the connector
 	Close() error
 }
+
+// Acker is implemented by source connectors that process the resources they
+// read once a job has loaded them, such as removing the files read
+type Acker interface {
+	// Ack processes the resources read since the last Ack, it is only called
+	// once the destination has committed them. Resources that are not acked
+	// are left as they were, so the next job reads them again.
+	Ack(ctx context.Context) error
+}
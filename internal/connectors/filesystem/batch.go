@@ -0,0 +1,418 @@
+package filesystem
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andrew-a-hale/mdf/internal/connectors"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/google/uuid"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// errAborted fails a copy whose writer was aborted
+var errAborted = errors.New("write aborted")
+
+// recordReader cuts the record batches of a query into batches of size rows,
+// the last one may be shorter. Records are sliced without copying, only a
+// batch spanning two records is concatenated. A reader without records is
+// empty.
+type recordReader struct {
+	records array.RecordReader
+	// cur is the part of the current record not returned yet
+	cur  arrow.Record
+	size int64
+	mem  memory.Allocator
+	// done is called once every row has been read
+	done func(records int)
+	rows int
+	// conn is the connection the union query ran on, it stays open until
+	// release is called
+	conn    *sql.Conn
+	union   string
+	release func()
+}
+
+// Next returns the next batch of the query result
+func (r *recordReader) Next(ctx context.Context) (arrow.Record, error) {
+	if r.records == nil {
+		return nil, io.EOF
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var parts []arrow.Record
+	defer func() {
+		for _, part := range parts {
+			part.Release()
+		}
+	}()
+	n := int64(0)
+	for n < r.size {
+		if r.cur == nil || r.cur.NumRows() == 0 {
+			more, err := r.advance()
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				break
+			}
+		}
+		take := min(r.size-n, r.cur.NumRows())
+		parts = append(parts, r.cur.NewSlice(0, take))
+		rest := r.cur.NewSlice(take, r.cur.NumRows())
+		r.cur.Release()
+		r.cur = rest
+		n += take
+	}
+
+	if n == 0 {
+		if r.done != nil {
+			r.done(r.rows)
+			r.done = nil
+		}
+		return nil, io.EOF
+	}
+	r.rows += int(n)
+	if len(parts) == 1 {
+		batch := parts[0]
+		parts = nil
+		return batch, nil
+	}
+	return concatRecords(parts, n, r.mem)
+}
+
+// advance moves to the next record of the query result, it returns false
+// once there are none left
+func (r *recordReader) advance() (bool, error) {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if !r.records.Next() {
+		if err := r.records.Err(); err != nil {
+			slog.Error("Error iterating records", "error", err)
+			return false, fmt.Errorf("error iterating records: %w", err)
+		}
+		return false, nil
+	}
+	r.cur = r.records.Record()
+	r.cur.Retain()
+	return true, nil
+}
+
+// concatRecords concatenates the columns of records of the same schema into
+// one record of rows rows
+func concatRecords(records []arrow.Record, rows int64, mem memory.Allocator) (arrow.Record, error) {
+	schema := records[0].Schema()
+	cols := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, col := range cols {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+	for i := range cols {
+		arrs := make([]arrow.Array, len(records))
+		for j, rec := range records {
+			arrs[j] = rec.Column(i)
+		}
+		col, err := array.Concatenate(arrs, mem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to concatenate records: %w", err)
+		}
+		cols[i] = col
+	}
+	return array.NewRecord(schema, cols, rows), nil
+}
+
+// FirstDuplicates implements connectors.DuplicateFinder. DuckDB numbers the
+// rows of each value in the union query, spilling to disk when they do not
+// fit in memory.
+func (r *recordReader) FirstDuplicates(ctx context.Context, fields []string) (map[string]int, error) {
+	if r.conn == nil {
+		return map[string]int{}, nil
+	}
+	var present []string
+	for _, field := range fields {
+		if r.records.Schema().HasField(field) && !slices.Contains(present, field) {
+			present = append(present, field)
+		}
+	}
+	_, duplicates, err := firstRows(ctx, r.conn, r.union, nil, present)
+	return duplicates, err
+}
+
+// Close releases the query result and closes its connection
+func (r *recordReader) Close() error {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if r.records != nil {
+		r.records.Release()
+		r.records = nil
+	}
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	r.conn = nil
+	return nil
+}
+
+// partitionWriter streams batches into a Parquet file in a partition. DuckDB
+// scans the batches through its arrow interface while they are written, so
+// only the batch being copied is held in memory.
+type partitionWriter struct {
+	db            *sql.DB
+	schema        *arrow.Schema
+	partitionName string
+	partitionPath string
+
+	stream *recordStream
+	// copied is closed once the copy returns, with its error in err
+	copied  chan struct{}
+	err     error
+	records int
+	closed  bool
+}
+
+// tmpPath is where the file is written until it is committed
+func (w *partitionWriter) tmpPath() string {
+	return w.partitionPath + ".tmp"
+}
+
+// start creates the partition directory and starts copying the stream to the
+// temporary file on the first batch, so nothing is created when there is no
+// data
+func (w *partitionWriter) start(ctx context.Context, schema *arrow.Schema) error {
+	// Ensure the partition directory exists
+	partitionDir := filepath.Dir(w.partitionPath)
+	if err := os.MkdirAll(partitionDir, 0755); err != nil {
+		slog.Error("Failed to create partition directory", "dir", partitionDir, "error", err)
+		return fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	// Arrow views only exist on the connection that registered them
+	conn, err := w.db.Conn(ctx)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	stream := newRecordStream(ctx, schema)
+	viewName := fmt.Sprintf("temp_stream_%s", strings.ReplaceAll(uuid.New().String(), "-", ""))
+	var release func()
+	err = conn.Raw(func(driverConn any) error {
+		ar, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		release, err = ar.RegisterView(stream, viewName)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		slog.Error("Failed to register arrow view", "error", err)
+		return fmt.Errorf("failed to register arrow view: %w", err)
+	}
+
+	w.stream = stream
+	w.copied = make(chan struct{})
+	go func() {
+		defer close(w.copied)
+		defer conn.Close()
+		defer release()
+
+		// Write the data to a temporary Parquet file using DuckDB's COPY
+		// statement, it is moved into place on commit
+		copySQL := fmt.Sprintf("COPY (SELECT * FROM %s) TO '%s' (FORMAT PARQUET)", viewName, w.tmpPath())
+		if _, err := conn.ExecContext(ctx, copySQL); err != nil {
+			w.err = err
+		}
+		if _, err := conn.ExecContext(context.Background(), fmt.Sprintf("DROP VIEW IF EXISTS %s", viewName)); err != nil {
+			slog.Warn("Failed to drop arrow view", "view", viewName, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Write hands a batch to the copy, it blocks until DuckDB reads the batch
+func (w *partitionWriter) Write(ctx context.Context, batch arrow.Record) error {
+	if w.closed {
+		return errors.New("writer is closed")
+	}
+	if batch.NumRows() == 0 {
+		return nil
+	}
+
+	if w.stream == nil {
+		schema := batch.Schema()
+		if w.schema != nil {
+			schema = w.schema
+		}
+		if err := w.start(ctx, schema); err != nil {
+			return err
+		}
+	}
+	if !connectors.SameSchema(batch.Schema(), w.stream.schema) {
+		return fmt.Errorf("batch schema does not match the declared fields: got %s, want %s", batch.Schema(), w.stream.schema)
+	}
+
+	batch.Retain()
+	select {
+	case w.stream.records <- batch:
+		w.records += int(batch.NumRows())
+		return nil
+	case <-w.copied:
+		batch.Release()
+		if w.err != nil {
+			slog.Error("Failed to write data to Parquet file", "path", w.partitionPath, "error", w.err)
+			return fmt.Errorf("failed to write data to Parquet file: %w", w.err)
+		}
+		return errors.New("failed to write data to Parquet file: copy stopped")
+	case <-ctx.Done():
+		batch.Release()
+		return ctx.Err()
+	}
+}
+
+// Commit finishes the copy and moves the file into place so readers never
+// see a partial file. The writer is closed whether or not it succeeds.
+func (w *partitionWriter) Commit(ctx context.Context) error {
+	if w.closed {
+		return errors.New("writer is closed")
+	}
+	w.closed = true
+	if w.stream == nil {
+		slog.Info("No data to write")
+		return nil
+	}
+
+	w.stream.finish(nil)
+	<-w.copied
+	if w.err != nil {
+		w.discard()
+		slog.Error("Failed to write data to Parquet file", "path", w.partitionPath, "error", w.err)
+		return fmt.Errorf("failed to write data to Parquet file: %w", w.err)
+	}
+	// Nothing is committed once cancelled
+	if err := ctx.Err(); err != nil {
+		w.discard()
+		return err
+	}
+	if err := os.Rename(w.tmpPath(), w.partitionPath); err != nil {
+		w.discard()
+		slog.Error("Failed to move Parquet file into place", "path", w.partitionPath, "error", err)
+		return fmt.Errorf("failed to move Parquet file into place: %w", err)
+	}
+
+	slog.Info("Wrote data to partitioned file", "path", w.partitionPath, "records", w.records, "partition", w.partitionName)
+	return nil
+}
+
+// Abort stops the copy and removes the temporary file
+func (w *partitionWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.stream == nil {
+		return nil
+	}
+
+	w.stream.finish(errAborted)
+	<-w.copied
+	return w.discard()
+}
+
+// discard removes the temporary file, and the partition directory when
+// nothing else is in it
+func (w *partitionWriter) discard() error {
+	if err := os.Remove(w.tmpPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove temporary file: %w", err)
+	}
+	os.Remove(filepath.Dir(w.partitionPath)) // only when empty
+	return nil
+}
+
+// recordStream is an array.RecordReader fed one batch at a time by a
+// partitionWriter. It is unbuffered, a write waits for DuckDB to read the
+// previous batch. The stream fails once ctx is done, so a cancelled copy
+// stops without waiting for the writer.
+type recordStream struct {
+	refs    atomic.Int64
+	ctx     context.Context
+	schema  *arrow.Schema
+	records chan arrow.Record
+	cur     arrow.Record
+	// err is set before records is closed
+	err error
+	// cancelled is set by Next when ctx is done
+	cancelled error
+}
+
+func newRecordStream(ctx context.Context, schema *arrow.Schema) *recordStream {
+	s := &recordStream{ctx: ctx, schema: schema, records: make(chan arrow.Record)}
+	s.refs.Store(1)
+	return s
+}
+
+// finish ends the stream, a non nil err fails the copy reading it
+func (s *recordStream) finish(err error) {
+	s.err = err
+	close(s.records)
+}
+
+func (s *recordStream) Retain() { s.refs.Add(1) }
+
+func (s *recordStream) Release() {
+	if s.refs.Add(-1) == 0 && s.cur != nil {
+		s.cur.Release()
+		s.cur = nil
+	}
+}
+
+func (s *recordStream) Schema() *arrow.Schema { return s.schema }
+
+func (s *recordStream) Next() bool {
+	if s.cur != nil {
+		s.cur.Release()
+		s.cur = nil
+	}
+	select {
+	case rec, ok := <-s.records:
+		if !ok {
+			return false
+		}
+		s.cur = rec
+		return true
+	case <-s.ctx.Done():
+		s.cancelled = s.ctx.Err()
+		return false
+	}
+}
+
+func (s *recordStream) Record() arrow.Record { return s.cur }
+
+func (s *recordStream) Err() error {
+	if s.cancelled != nil {
+		return s.cancelled
+	}
+	return s.err
+}
@@ -0,0 +1,101 @@
+package filesystem
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/andrew-a-hale/mdf/internal/connectors"
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// partitions lists the supported partition types
+var partitions = []string{"daily", "hourly", "monthly"}
+
+// What happens to the files read from a source directory once a job has
+// loaded them
+const (
+	// PROCESSED_DELETE removes the files
+	PROCESSED_DELETE = "delete"
+	// PROCESSED_ARCHIVE moves the files to archive/<partition>/ in the
+	// directory
+	PROCESSED_ARCHIVE = "archive"
+	// PROCESSED_LEAVE leaves the files and records them in the processed
+	// registry of the directory, so they are not read again
+	PROCESSED_LEAVE = "leave"
+)
+
+// processedPolicies lists the supported processed file policies
+var processedPolicies = []string{PROCESSED_DELETE, PROCESSED_ARCHIVE, PROCESSED_LEAVE}
+
+// Config represents the settings of a filesystem connector
+type Config struct {
+	BasePath  string `yaml:"base_path"`
+	Partition string `yaml:"partition"`
+	// Processed is what happens to the files read from a source directory once
+	// they are loaded, defaults to delete
+	Processed string `yaml:"processed,omitempty"`
+}
+
+func init() {
+	connectors.Register(connectors.FILESYSTEM, connectors.Registration{
+		NewSettings: func() parser.ConnectorSettings {
+			return &Config{Partition: "daily", Processed: PROCESSED_DELETE}
+		},
+		New: open,
+	})
+}
+
+// Validate checks the settings once defaults have been applied
+func (c *Config) Validate() error {
+	if c.BasePath == "" {
+		return fmt.Errorf("base_path is required")
+	}
+	if !slices.Contains(partitions, c.Partition) {
+		return fmt.Errorf("invalid partition type: %s, must be one of: %v", c.Partition, partitions)
+	}
+	if c.Processed != "" && !slices.Contains(processedPolicies, c.Processed) {
+		return fmt.Errorf("invalid processed policy: %s, must be one of: %v", c.Processed, processedPolicies)
+	}
+	return nil
+}
+
+// open creates a filesystem connector from its settings
+func open(settings parser.ConnectorSettings, opts connectors.Options) (connectors.Connector, error) {
+	c, ok := settings.(*Config)
+	if !ok {
+		return nil, fmt.Errorf("expected filesystem settings, got %T", settings)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Resolve relative paths against the working root
+	path := c.BasePath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(opts.WorkDir, path)
+	}
+
+	// Sources may narrow the connector root to a single resource
+	switch opts.Role {
+	case connectors.SOURCE:
+		path = filepath.Join(path, opts.DataSource.Source.FQNResource)
+	case connectors.DESTINATION:
+	default:
+		return nil, fmt.Errorf("unknown connector role: %s", opts.Role)
+	}
+
+	fc, err := New(path, c.Partition, opts.DataSource.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if c.Processed != "" {
+		fc.Processed = c.Processed
+	}
+	return fc, nil
+}
+
+// SchemaEnums implements parser.SchemaEnumer
+func (Config) SchemaEnums() map[string][]string {
+	return map[string][]string{"partition": partitions, "processed": processedPolicies}
+}
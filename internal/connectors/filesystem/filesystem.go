@@ -1,27 +1,49 @@
 package filesystem
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/andrew-a-hale/mdf/internal/connectors"
 	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
 	"github.com/google/uuid"
-	_ "github.com/marcboeker/go-duckdb" // Import for side effect of registering driver
+	"github.com/marcboeker/go-duckdb"
 )
 
 // FilesystemConnector represents a filesystem connector using DuckDB as the engine
 type FilesystemConnector struct {
-	BasePath       string
-	Partition      string
-	db             *sql.DB
+	BasePath  string
+	Partition string
+	db        *sql.DB
+	// ProcessedFiles holds the entries of the processed registry of the
+	// source directory, files in it are not read
 	ProcessedFiles map[string]bool
 	Fields         []parser.FieldConfig
+	// schema is the arrow schema of the declared fields, nil when there are
+	// none
+	schema *arrow.Schema
+	// BatchSize is the maximum number of rows read into a batch
+	BatchSize int
+	// Processed is the policy applied by Ack to the files read from a
+	// directory
+	Processed string
+	// unacked are the files read from a directory since the last Ack
+	unacked []string
+	// allocator allocates the batches concatenated from two records
+	allocator memory.Allocator
 }
 
 // New creates a new filesystem connector
@@ -47,6 +69,12 @@ func New(basePath string, partition string, fields []parser.FieldConfig) (*Files
 		return nil, fmt.Errorf("invalid partition type: %s, must be one of: daily, hourly, monthly", partition)
 	}
 
+	schema, err := connectors.Schema(fields)
+	if err != nil {
+		slog.Error("Invalid fields", "error", err)
+		return nil, err
+	}
+
 	// Initialize DuckDB in-memory database
 	db, err := sql.Open("duckdb", ":memory:")
 	if err != nil {
@@ -60,6 +88,10 @@ func New(basePath string, partition string, fields []parser.FieldConfig) (*Files
 		Partition: partition,
 		db:        db,
 		Fields:    fields,
+		schema:    schema,
+		BatchSize: connectors.BATCH_SIZE,
+		Processed: PROCESSED_DELETE,
+		allocator: memory.DefaultAllocator,
 	}, nil
 }
 
@@ -72,39 +104,107 @@ func (fc *FilesystemConnector) Close() error {
 }
 
 // Read reads data from a file or directory using DuckDB
-func (fc *FilesystemConnector) Read() ([]map[string]any, error) {
+func (fc *FilesystemConnector) Read(ctx context.Context) ([]map[string]any, error) {
+	reader, err := fc.ReadBatches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return connectors.ReadAll(ctx, reader)
+}
+
+// ReadBatches streams a file or directory as arrow record batches of up to
+// BatchSize rows, cast to the declared fields. Files read from a directory are
+// processed by the next Ack once the last batch has been read.
+func (fc *FilesystemConnector) ReadBatches(ctx context.Context) (connectors.BatchReader, error) {
+	files, dir, err := fc.sourceFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return &recordReader{}, nil
+	}
+
+	// Use DuckDB to read all files at once
+	reader, err := fc.readFiles(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are processed once every row has been read and loaded
+	if dir {
+		reader.done = func(records int) {
+			fc.unacked = files
+			slog.Info("Read from directory", "dir", fc.BasePath, "files", len(files), "records", records)
+		}
+	}
+	return reader, nil
+}
+
+// sourceFiles returns the file the connector reads, or the supported files in
+// its directory, and whether it reads a directory
+func (fc *FilesystemConnector) sourceFiles(ctx context.Context) ([]string, bool, error) {
 	fileInfo, err := os.Stat(fc.BasePath)
 	if err != nil {
 		slog.Error("Resource not found", "resource", fc.BasePath)
-		return nil, fmt.Errorf("resource not found: %s", fc.BasePath)
+		return nil, false, fmt.Errorf("resource not found: %s", fc.BasePath)
 	}
 
 	// If it's a directory, process it as a directory resource
 	if fileInfo.IsDir() {
-		return fc.readFromDirectory()
+		files, err := fc.readFromDirectory(ctx)
+		return files, true, err
 	}
 
 	// It's a single file, process it directly
 	ext := filepath.Ext(fc.BasePath)
 	slog.Info("Reading from file", "path", fc.BasePath, "format", ext)
 
-	return fc.readFile(fc.BasePath)
+	if !isSupportedFileType(ext) {
+		slog.Error("Unsupported file format", "format", ext, "file", fc.BasePath)
+		return nil, false, fmt.Errorf("unsupported file format: %s", ext)
+	}
+	return []string{fc.BasePath}, false, nil
 }
 
-// readFromDirectory reads all files from a directory and combines the results using DuckDB
-func (fc *FilesystemConnector) readFromDirectory() ([]map[string]any, error) {
+// readFromDirectory lists the supported files in a directory, skipping the
+// archive and the files in the processed registry. It stops when ctx is done.
+func (fc *FilesystemConnector) readFromDirectory(ctx context.Context) ([]string, error) {
+	if err := fc.loadRegistry(); err != nil {
+		slog.Error("Failed to load processed registry", "dir", fc.BasePath, "error", err)
+		return nil, err
+	}
+
+	archive := filepath.Join(fc.BasePath, archiveDir)
 	var allFiles []string
 	err := filepath.WalkDir(fc.BasePath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		if d.IsDir() {
+			if path == archive {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
 		ext := filepath.Ext(path)
-		if isSupportedFileType(ext) {
+		if !isSupportedFileType(ext) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry, err := fc.registryEntry(path, info)
+		if err != nil {
+			return err
+		}
+		if !fc.ProcessedFiles[entry] {
 			allFiles = append(allFiles, path)
 		}
 
@@ -117,30 +217,63 @@ func (fc *FilesystemConnector) readFromDirectory() ([]map[string]any, error) {
 
 	if len(allFiles) == 0 {
 		slog.Info("No files to process in directory", "dir", fc.BasePath)
-		return []map[string]any{}, nil
+	}
+	return allFiles, nil
+}
+
+// readFiles opens a reader over the union of multiple files using DuckDB.
+// DuckDB's arrow interface exports the result as records that are cut into
+// batches, the connection stays open until the reader is closed.
+func (fc *FilesystemConnector) readFiles(ctx context.Context, filePaths []string) (*recordReader, error) {
+	// Temporary views only exist on the connection that created them
+	conn, err := fc.db.Conn(ctx)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Use DuckDB to read all files at once
-	result, err := fc.readFiles(allFiles)
+	query, drop, err := fc.unionQuery(ctx, conn, filePaths)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-
-	// Remove processed files
-	for _, filePath := range allFiles {
-		os.Remove(filePath)
+	release := func() {
+		drop()
+		conn.Close()
 	}
 
-	slog.Info("Read from directory", "dir", fc.BasePath, "files", len(allFiles), "records", len(result))
-	return result, nil
+	// Query all files as arrow record batches
+	var records array.RecordReader
+	err = conn.Raw(func(driverConn any) error {
+		ar, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		records, err = ar.QueryContext(ctx, orderedQuery(query))
+		return err
+	})
+	if err != nil {
+		release()
+		slog.Error("Failed to query files", "query", query, "error", err)
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+
+	return &recordReader{
+		records: records,
+		size:    int64(fc.BatchSize),
+		mem:     fc.allocator,
+		conn:    conn,
+		union:   query,
+		release: release,
+	}, nil
 }
 
-// readFiles reads multiple files using DuckDB
-func (fc *FilesystemConnector) readFiles(filePaths []string) ([]map[string]any, error) {
-	if len(filePaths) == 0 {
-		return []map[string]any{}, nil
-	}
-
+// unionQuery creates a temporary view for each file on conn and returns a
+// query over the union of the files, cast to the declared fields. Each row
+// also has the index of its file in mdf_file and its position in the file in
+// mdf_file_row, so rows are numbered the same way by every query, see
+// rowOrder. drop drops the views.
+func (fc *FilesystemConnector) unionQuery(ctx context.Context, conn *sql.Conn, filePaths []string) (string, func(), error) {
 	// Create a temporary view that unifies all files
 	viewName := fmt.Sprintf("temp_view_%s", strings.ReplaceAll(uuid.New().String(), "-", ""))
 
@@ -151,171 +284,114 @@ func (fc *FilesystemConnector) readFiles(filePaths []string) ([]map[string]any,
 		filesByExt[ext] = append(filesByExt[ext], path)
 	}
 
-	// Process each file type and union the results
-	var unionQueries []string
+	// Process each file type and union the results, casting the columns to
+	// the declared fields
+	var unionQueries, views []string
+	drop := func() {
+		for _, view := range views {
+			if _, err := conn.ExecContext(context.Background(), fmt.Sprintf("DROP VIEW IF EXISTS %s", view)); err != nil {
+				slog.Warn("Failed to drop temporary view", "view", view, "error", err)
+			}
+		}
+	}
 
-	for ext, files := range filesByExt {
-		var format string
+	for _, ext := range slices.Sorted(maps.Keys(filesByExt)) {
+		var format, readFunc string
 		switch ext {
 		case ".csv":
-			format = "csv"
+			format, readFunc = "csv", "read_csv_auto"
 		case ".json":
-			format = "json"
+			format, readFunc = "json", "read_json_auto"
 		case ".jsonl":
-			format = "jsonline"
+			format, readFunc = "jsonline", "read_ndjson_auto"
 		case ".parquet":
-			format = "parquet"
+			format, readFunc = "parquet", "read_parquet"
 		default:
 			continue
 		}
 
-		// Create temp view for each file type
-		for i, file := range files {
+		// Create temp view for each file type. Only the parquet reader numbers
+		// the rows of a file, the others are numbered in the order they are
+		// scanned, which DuckDB keeps for a single file.
+		for i, file := range filesByExt[ext] {
 			subViewName := fmt.Sprintf("%s_%s_%d", viewName, format, i)
-			readQuery := fmt.Sprintf("CREATE TEMPORARY VIEW %s AS SELECT * FROM read_%s_auto('%s')",
-				subViewName, format, file)
+			readQuery := fmt.Sprintf("CREATE TEMPORARY VIEW %s AS SELECT *, row_number() OVER () - 1 AS mdf_file_row FROM %s('%s')",
+				subViewName, readFunc, file)
+			if format == "parquet" {
+				readQuery = fmt.Sprintf("CREATE TEMPORARY VIEW %s AS SELECT * EXCLUDE (file_row_number), file_row_number AS mdf_file_row FROM %s('%s', file_row_number=true)",
+					subViewName, readFunc, file)
+			}
 
-			_, err := fc.db.Exec(readQuery)
+			_, err := conn.ExecContext(ctx, readQuery)
 			if err != nil {
+				drop()
 				slog.Error("Failed to create temporary view", "query", readQuery, "error", err)
-				return nil, fmt.Errorf("failed to create temporary view: %w", err)
+				return "", nil, fmt.Errorf("failed to read file %s: %w", file, err)
 			}
+			views = append(views, subViewName)
 
-			unionQueries = append(unionQueries, fmt.Sprintf("SELECT * FROM %s", subViewName))
+			unionQueries = append(unionQueries, fmt.Sprintf("SELECT %s, %d AS mdf_file, mdf_file_row FROM %s",
+				fc.projection(), len(views)-1, subViewName))
 		}
 	}
 
-	if len(unionQueries) == 0 {
-		return []map[string]any{}, nil
-	}
-
-	// Create a unified view with all data
-	unifiedQuery := fmt.Sprintf("CREATE TEMPORARY VIEW %s AS %s", viewName, strings.Join(unionQueries, " UNION ALL "))
-	_, err := fc.db.Exec(unifiedQuery)
-	if err != nil {
-		slog.Error("Failed to create unified view", "query", unifiedQuery, "error", err)
-		return nil, fmt.Errorf("failed to create unified view: %w", err)
-	}
-
-	// Query the unified view
-	return fc.queryView(viewName)
+	return strings.Join(unionQueries, " UNION ALL "), drop, nil
 }
 
-// readFile reads a single file using DuckDB
-func (fc *FilesystemConnector) readFile(filePath string) ([]map[string]any, error) {
-	ext := strings.ToLower(filepath.Ext(filePath))
+// rowOrder orders the rows of a union query by file and by position in the
+// file
+const rowOrder = "mdf_file, mdf_file_row"
 
-	if !isSupportedFileType(ext) {
-		slog.Error("Unsupported file format", "format", ext, "file", filePath)
-		return nil, fmt.Errorf("unsupported file format: %s", ext)
-	}
+// orderedQuery returns the rows of a union query in rowOrder, without the
+// columns numbering them
+func orderedQuery(union string) string {
+	return fmt.Sprintf("SELECT * EXCLUDE (mdf_file, mdf_file_row) FROM (%s) ORDER BY %s", union, rowOrder)
+}
 
-	var format string
-	switch ext {
-	case ".csv":
-		format = "csv"
-	case ".json":
-		format = "json"
-	case ".jsonl":
-		format = "jsonline"
-	case ".parquet":
-		format = "parquet"
+// projection selects the declared fields cast to their data type, or every
+// column when no fields are declared
+func (fc *FilesystemConnector) projection() string {
+	if len(fc.Fields) == 0 {
+		return "* EXCLUDE (mdf_file_row)"
 	}
-
-	// Create a temporary view for the file
-	viewName := fmt.Sprintf("temp_view_%s", strings.ReplaceAll(uuid.New().String(), "-", ""))
-	readQuery := fmt.Sprintf("CREATE TEMPORARY VIEW %s AS SELECT * FROM read_%s_auto('%s')",
-		viewName, format, filePath)
-
-	_, err := fc.db.Exec(readQuery)
-	if err != nil {
-		slog.Error("Failed to create temporary view", "query", readQuery, "error", err)
-		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	cols := make([]string, 0, len(fc.Fields))
+	for _, field := range fc.Fields {
+		cols = append(cols, fmt.Sprintf("CAST(%s AS %s) AS %s", field.Label, field.DataType, field.Label))
 	}
-
-	return fc.queryView(viewName)
+	return strings.Join(cols, ", ")
 }
 
-// queryView executes a query against a view and returns the results as a slice of maps
-func (fc *FilesystemConnector) queryView(viewName string) ([]map[string]any, error) {
-	// Query the view
-	rows, err := fc.db.Query(fmt.Sprintf("SELECT * FROM %s", viewName))
-	if err != nil {
-		slog.Error("Failed to query view", "view", viewName, "error", err)
-		return nil, fmt.Errorf("failed to query view: %w", err)
-	}
-	defer rows.Close()
-
-	// Get column names
-	columns, err := rows.Columns()
+// Write writes data to a partitioned directory using DuckDB
+func (fc *FilesystemConnector) Write(ctx context.Context, data []map[string]any) error {
+	writer, err := fc.WriteBatches(ctx)
 	if err != nil {
-		slog.Error("Failed to get column names", "error", err)
-		return nil, fmt.Errorf("failed to get column names: %w", err)
-	}
-
-	// Prepare for scan
-	values := make([]any, len(columns))
-	valuePtrs := make([]any, len(columns))
-	for i := range columns {
-		valuePtrs[i] = &values[i]
-	}
-
-	// Process rows
-	var result []map[string]any
-	for rows.Next() {
-		if err := rows.Scan(valuePtrs...); err != nil {
-			slog.Error("Failed to scan row", "error", err)
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Create map for this row
-		entry := make(map[string]any)
-		for i, col := range columns {
-			// Convert DuckDB types to appropriate Go types
-			val := values[i]
-			if val != nil {
-				entry[col] = val
-			} else {
-				entry[col] = nil
-			}
-		}
-		result = append(result, entry)
-	}
-
-	if err := rows.Err(); err != nil {
-		slog.Error("Error iterating rows", "error", err)
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+		return err
 	}
+	return connectors.WriteAll(ctx, writer, fc.schema, data)
+}
 
-	// Drop the temporary view
-	_, err = fc.db.Exec(fmt.Sprintf("DROP VIEW IF EXISTS %s", viewName))
+// WriteBatches opens a writer to a new Parquet file in the current partition.
+// DuckDB copies the batches to a temporary file as they are written, which is
+// moved into place on Commit so readers never see a partial file.
+func (fc *FilesystemConnector) WriteBatches(ctx context.Context) (connectors.BatchWriter, error) {
+	partitionName, partitionPath, err := fc.partitionFile()
 	if err != nil {
-		slog.Warn("Failed to drop temporary view", "view", viewName, "error", err)
+		return nil, err
 	}
-
-	return result, nil
+	return &partitionWriter{
+		db:            fc.db,
+		schema:        fc.schema,
+		partitionName: partitionName,
+		partitionPath: partitionPath,
+	}, nil
 }
 
-// Write writes data to a partitioned directory using DuckDB
-func (fc *FilesystemConnector) Write(data []map[string]any) error {
-	if len(data) == 0 {
-		slog.Info("No data to write")
-		return nil
-	}
-
+// partitionFile returns the current partition and a new file path in it
+func (fc *FilesystemConnector) partitionFile() (string, string, error) {
 	// Create partition directory name based on current time
-	var partitionName string
-	now := time.Now().UTC()
-
-	switch fc.Partition {
-	case "hourly":
-		partitionName = now.Format("2006-01-02-15")
-	case "daily":
-		partitionName = now.Format("2006-01-02")
-	case "monthly":
-		partitionName = now.Format("2006-01")
-	default:
-		return fmt.Errorf("invalid partition type: %s", fc.Partition)
+	partitionName := fc.partitionName(time.Now().UTC())
+	if partitionName == "" {
+		return "", "", fmt.Errorf("invalid partition type: %s", fc.Partition)
 	}
 
 	// Generate a unique filename for this write
@@ -323,64 +399,22 @@ func (fc *FilesystemConnector) Write(data []map[string]any) error {
 
 	// Create the full path including the partition
 	partitionDir := filepath.Join(fc.BasePath, partitionName)
-	partitionPath := filepath.Join(partitionDir, resourceFile)
-
-	// Ensure the partition directory exists
-	if err := os.MkdirAll(partitionDir, 0755); err != nil {
-		slog.Error("Failed to create partition directory", "dir", partitionDir, "error", err)
-		return fmt.Errorf("failed to create partition directory: %w", err)
-	}
-
-	// Create a temporary table
-	tx, err := fc.db.Begin()
-	if err != nil {
-		slog.Error("Failed to connect to database", "error", err)
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-	defer tx.Commit()
-
-	tableName := fmt.Sprintf("temp_table_%s", strings.ReplaceAll(uuid.New().String(), "-", ""))
-	var cols []string
-	for _, field := range fc.Fields {
-		cols = append(cols, fmt.Sprintf("%s %s", field.Label, field.DataType))
-	}
-	createTableSql := fmt.Sprintf("CREATE TEMPORARY TABLE %s (%s)", tableName, strings.Join(cols, ","))
-	_, err = tx.Exec(createTableSql)
-	if err != nil {
-		slog.Error("Failed to create table", "error", err)
-		return fmt.Errorf("failed to create table: %w", err)
-	}
-
-	// Insert data into table
-	clear(cols)
-	for _, field := range fc.Fields {
-		cols = append(cols, field.Label)
-	}
-	insertSql := fmt.Sprintf(
-		"INSERT INTO %s (%s) VALUES (%s)",
-		tableName,
-		strings.Join(cols, ","),
-		strings.Repeat("?", len(cols)),
-	)
-
-	for _, row := range data {
-		var fields []any
-		for _, field := range row {
-			fields = append(fields, field)
-		}
-		tx.Exec(insertSql, fields)
-	}
+	return partitionName, filepath.Join(partitionDir, resourceFile), nil
+}
 
-	// Write the data to Parquet file using DuckDB's COPY statement
-	copySQL := fmt.Sprintf("COPY (SELECT * FROM %s) TO '%s' (FORMAT PARQUET)", tableName, partitionPath)
-	_, err = tx.Exec(copySQL)
-	if err != nil {
-		slog.Error("Failed to write data to Parquet file", "path", partitionPath, "error", err)
-		return fmt.Errorf("failed to write data to Parquet file: %w", err)
+// partitionName returns the partition of a time, or an empty string for an
+// invalid partition type
+func (fc *FilesystemConnector) partitionName(t time.Time) string {
+	switch fc.Partition {
+	case "hourly":
+		return t.Format("2006-01-02-15")
+	case "daily":
+		return t.Format("2006-01-02")
+	case "monthly":
+		return t.Format("2006-01")
+	default:
+		return ""
 	}
-
-	slog.Info("Wrote data to partitioned file", "path", partitionPath, "records", len(data), "partition", partitionName)
-	return nil
 }
 
 // isSupportedFileType checks if the file extension is supported
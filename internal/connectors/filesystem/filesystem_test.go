@@ -1,11 +1,23 @@
 package filesystem
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andrew-a-hale/mdf/internal/connectors"
 	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/andrew-a-hale/mdf/internal/validator"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/marcboeker/go-duckdb"
 )
 
 func TestNew(t *testing.T) {
@@ -111,7 +123,7 @@ func TestWrite(t *testing.T) {
 	defer fc.Close()
 
 	// Test writing empty data
-	if err := fc.Write([]map[string]any{}); err != nil {
+	if err := fc.Write(context.Background(), []map[string]any{}); err != nil {
 		t.Errorf("Write with empty data should not error: %v", err)
 	}
 
@@ -129,7 +141,7 @@ func TestWrite(t *testing.T) {
 		},
 	}
 
-	if err := fc.Write(testData); err != nil {
+	if err := fc.Write(context.Background(), testData); err != nil {
 		t.Errorf("Failed to write test data: %v", err)
 	}
 
@@ -289,7 +301,7 @@ func TestRead(t *testing.T) {
 			}
 			defer fc.Close()
 
-			data, err := fc.Read()
+			data, err := fc.Read(context.Background())
 
 			if tt.expectError {
 				if err == nil {
@@ -318,9 +330,12 @@ func TestRead(t *testing.T) {
 				}
 			}
 
-			// Test reading the same file again - ProcessedFiles should skip it
+			// Test reading the same file again - acked files are deleted
+			if err := fc.Ack(context.Background()); err != nil {
+				t.Fatalf("Failed to ack data: %v", err)
+			}
 			if !tt.expectError && tt.basePath == subDir {
-				data2, err := fc.Read()
+				data2, err := fc.Read(context.Background())
 				if err != nil {
 					t.Fatalf("Failed to read data second time: %v", err)
 				}
@@ -344,3 +359,715 @@ func TestRead(t *testing.T) {
 		}
 	})
 }
+
+func TestConfig(t *testing.T) {
+	settings, ok := parser.NewConnectorSettings(connectors.FILESYSTEM)
+	if !ok {
+		t.Fatal("filesystem settings should be registered with the parser")
+	}
+
+	// Defaults are applied before decoding
+	c := settings.(*Config)
+	if c.Partition != "daily" {
+		t.Errorf("Partition = %v, want daily", c.Partition)
+	}
+	if c.Processed != PROCESSED_DELETE {
+		t.Errorf("Processed = %v, want %v", c.Processed, PROCESSED_DELETE)
+	}
+
+	tests := []struct {
+		name        string
+		config      Config
+		expectError bool
+	}{
+		{name: "Valid", config: Config{BasePath: "raw", Partition: "hourly"}},
+		{name: "Missing Base Path", config: Config{Partition: "daily"}, expectError: true},
+		{name: "Invalid Partition", config: Config{BasePath: "raw", Partition: "weekly"}, expectError: true},
+		{name: "Archive Processed", config: Config{BasePath: "raw", Partition: "daily", Processed: PROCESSED_ARCHIVE}},
+		{name: "Invalid Processed", config: Config{BasePath: "raw", Partition: "daily", Processed: "shred"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error but got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	workDir, err := os.MkdirTemp("", "filesystem-test-open-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := os.MkdirAll(filepath.Join(workDir, "landing", "users"), 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	ds := parser.DataSource{Source: parser.SourceConfig{FQNResource: "users"}}
+
+	tests := []struct {
+		name        string
+		config      Config
+		role        connectors.Role
+		expectPath  string
+		expectError bool
+	}{
+		{
+			name:       "Relative Source With Resource",
+			config:     Config{BasePath: "landing", Partition: "daily"},
+			role:       connectors.SOURCE,
+			expectPath: filepath.Join(workDir, "landing", "users"),
+		},
+		{
+			name:       "Relative Destination",
+			config:     Config{BasePath: "landing", Partition: "daily"},
+			role:       connectors.DESTINATION,
+			expectPath: filepath.Join(workDir, "landing"),
+		},
+		{
+			name:       "Absolute Destination",
+			config:     Config{BasePath: filepath.Join(workDir, "landing"), Partition: "daily"},
+			role:       connectors.DESTINATION,
+			expectPath: filepath.Join(workDir, "landing"),
+		},
+		{
+			name:        "Missing Base Path",
+			config:      Config{Partition: "daily"},
+			role:        connectors.DESTINATION,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := open(&tt.config, connectors.Options{Role: tt.role, DataSource: ds, WorkDir: workDir})
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to open connector: %v", err)
+			}
+			defer c.Close()
+
+			if fc := c.(*FilesystemConnector); fc.BasePath != tt.expectPath {
+				t.Errorf("BasePath = %v, want %v", fc.BasePath, tt.expectPath)
+			}
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "filesystem-test-batches-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	source := filepath.Join(tempDir, "raw")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	csvData := "id,name\n1,a\n2,b\n3,c\n4,d\n5,e\n"
+	if err := os.WriteFile(filepath.Join(source, "test.csv"), []byte(csvData), 0644); err != nil {
+		t.Fatalf("Failed to create test CSV file: %v", err)
+	}
+
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "name", DataType: "string"},
+	}
+	src, err := New(source, "daily", fields)
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	defer src.Close()
+	src.BatchSize = 2
+
+	dest := filepath.Join(tempDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	dst, err := New(dest, "daily", fields)
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+	reader, err := src.ReadBatches(ctx)
+	if err != nil {
+		t.Fatalf("ReadBatches() error = %v", err)
+	}
+	defer reader.Close()
+	writer, err := dst.WriteBatches(ctx)
+	if err != nil {
+		t.Fatalf("WriteBatches() error = %v", err)
+	}
+
+	var sizes []int
+	for {
+		batch, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		sizes = append(sizes, int(batch.NumRows()))
+		if !batch.Schema().Equal(src.schema) {
+			t.Errorf("Batch schema = %s, want %s", batch.Schema(), src.schema)
+		}
+		err = writer.Write(ctx, batch)
+		batch.Release()
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Errorf("Batch sizes = %v, want [2 2 1]", sizes)
+	}
+
+	// Nothing is visible before commit
+	parquet, _ := filepath.Glob(filepath.Join(dest, "*", "*.parquet"))
+	if len(parquet) != 0 {
+		t.Errorf("Found %v before commit", parquet)
+	}
+	if err := writer.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	// The batches are combined into one partition file
+	parquet, _ = filepath.Glob(filepath.Join(dest, "*", "*"))
+	if len(parquet) != 1 || filepath.Ext(parquet[0]) != ".parquet" {
+		t.Fatalf("Partition holds %v, want one parquet file", parquet)
+	}
+	var count int
+	if err := dst.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM read_parquet('%s')", parquet[0])).Scan(&count); err != nil {
+		t.Fatalf("Failed to read parquet file: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("Parquet file has %d rows, want 5", count)
+	}
+
+	// Aborted batches leave nothing behind
+	writer, _ = dst.WriteBatches(ctx)
+	batch, err := connectors.NewRecord(dst.schema, []map[string]any{{"id": 6, "name": "f"}})
+	if err != nil {
+		t.Fatalf("NewRecord() error = %v", err)
+	}
+	defer batch.Release()
+	if err := writer.Write(ctx, batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dest, "*", "*"))
+	if len(entries) != 1 {
+		t.Errorf("Partition holds %v after abort, want only the committed file", entries)
+	}
+}
+
+func TestReadBatchSizes(t *testing.T) {
+	// DuckDB exports records of 2048 rows, batches span records and files
+	source := t.TempDir()
+	for _, name := range []string{"a.csv", "b.csv"} {
+		var csvData strings.Builder
+		csvData.WriteString("id,name\n")
+		for i := range 2500 {
+			fmt.Fprintf(&csvData, "%d,name-%d\n", i, i)
+		}
+		if err := os.WriteFile(filepath.Join(source, name), []byte(csvData.String()), 0644); err != nil {
+			t.Fatalf("Failed to create test CSV file: %v", err)
+		}
+	}
+
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "name", DataType: "string"},
+	}
+	fc, err := New(source, "daily", fields)
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	defer fc.Close()
+	fc.BatchSize = 1500
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	fc.allocator = mem
+
+	ctx := context.Background()
+	reader, err := fc.ReadBatches(ctx)
+	if err != nil {
+		t.Fatalf("ReadBatches() error = %v", err)
+	}
+
+	var sizes []int
+	for {
+		batch, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		sizes = append(sizes, int(batch.NumRows()))
+		if !batch.Schema().Equal(fc.schema) {
+			t.Errorf("Batch schema = %s, want %s", batch.Schema(), fc.schema)
+		}
+		batch.Release()
+	}
+	if !slices.Equal(sizes, []int{1500, 1500, 1500, 500}) {
+		t.Errorf("Batch sizes = %v, want [1500 1500 1500 500]", sizes)
+	}
+
+	// Concatenated batches are released with the batch
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	mem.AssertSize(t, 0)
+}
+
+func TestReadTypes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "filesystem-test-types-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	source := filepath.Join(tempDir, "test.csv")
+	csvData := "id,total,price,amount,ratio,score,active,name,born,seen\n" +
+		"1,9000000000,1.25,1234567.89,0.5,0.25,true,a,2000-01-31,2025-01-02 03:04:05\n"
+	if err := os.WriteFile(source, []byte(csvData), 0644); err != nil {
+		t.Fatalf("Failed to create test CSV file: %v", err)
+	}
+
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "total", DataType: "bigint"},
+		{Label: "price", DataType: "decimal"},
+		{Label: "amount", DataType: "decimal(22,2)"},
+		{Label: "ratio", DataType: "double"},
+		{Label: "score", DataType: "float"},
+		{Label: "active", DataType: "boolean"},
+		{Label: "name", DataType: "varchar"},
+		{Label: "born", DataType: "date"},
+		{Label: "seen", DataType: "timestamp"},
+	}
+	fc, err := New(source, "daily", fields)
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	defer fc.Close()
+
+	reader, err := fc.ReadBatches(context.Background())
+	if err != nil {
+		t.Fatalf("ReadBatches() error = %v", err)
+	}
+	defer reader.Close()
+	batch, err := reader.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	defer batch.Release()
+
+	// DuckDB returns the declared schema
+	if !batch.Schema().Equal(fc.schema) {
+		t.Errorf("Batch schema = %s, want %s", batch.Schema(), fc.schema)
+	}
+	row := connectors.Rows(batch)[0]
+	want := map[string]any{
+		"id":     int32(1),
+		"total":  int64(9000000000),
+		"ratio":  0.5,
+		"score":  float32(0.25),
+		"active": true,
+		"name":   "a",
+		"born":   time.Date(2000, 1, 31, 0, 0, 0, 0, time.UTC),
+		"seen":   time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	for field, value := range want {
+		if got := row[field]; got != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", field, got, got, value, value)
+		}
+	}
+
+	// Decimals keep their precision, scale and every digit
+	for field, value := range map[string]string{"price": "1.25", "amount": "1234567.89"} {
+		d, ok := row[field].(duckdb.Decimal)
+		if !ok || d.String() != value {
+			t.Errorf("%s = %v (%T), want %s", field, row[field], row[field], value)
+		}
+	}
+}
+
+func TestLoadFrom(t *testing.T) {
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "name", DataType: "string"},
+	}
+
+	tests := []struct {
+		name  string
+		csv   string
+		rules parser.ValidationConfig
+	}{
+		{name: "Valid", csv: "id,name\n3,c\n1,a\n2,b\n5,e\n4,d\n", rules: parser.ValidationConfig{NotNull: []string{"id"}, Unique: []string{"id"}}},
+		{name: "No Rules", csv: "id,name\n1,a\n1,a\n"},
+		{name: "Empty", csv: "id,name\n"},
+		{name: "Null", csv: "id,name\n1,a\n2,\n3,c\n", rules: parser.ValidationConfig{NotNull: []string{"id", "name"}}},
+		{name: "Duplicate", csv: "id,name\n1,a\n2,b\n3,c\n2,d\n", rules: parser.ValidationConfig{Unique: []string{"name", "id"}}},
+		// The stream stops at the batch of the first broken rule, checking
+		// not null fields first
+		{name: "First Batch", csv: "id,name\n1,a\n1,b\n3,c\n4,\n", rules: parser.ValidationConfig{NotNull: []string{"name"}, Unique: []string{"id"}}},
+		{name: "Same Batch", csv: "id,name\n1,a\n2,b\n3,c\n3,\n", rules: parser.ValidationConfig{NotNull: []string{"name"}, Unique: []string{"id"}}},
+		{name: "Missing Field", csv: "id,name\n1,a\n", rules: parser.ValidationConfig{NotNull: []string{"age"}, Unique: []string{"age"}}},
+	}
+
+	// load runs a job in one query or through the batches, checking unique
+	// fields in the stream or with the duplicates found by the reader, and
+	// returns the rows written and the error
+	load := func(t *testing.T, csv string, rules parser.ValidationConfig, mode string) ([]map[string]any, error) {
+		dir := t.TempDir()
+		source := filepath.Join(dir, "in.csv")
+		if err := os.WriteFile(source, []byte(csv), 0644); err != nil {
+			t.Fatal(err)
+		}
+		src, err := New(source, "daily", fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		src.BatchSize = 2
+		dest := filepath.Join(dir, "out")
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := New(dest, "daily", fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+
+		ctx := context.Background()
+		if mode == "direct" {
+			_, err = dst.LoadFrom(ctx, src, rules)
+		} else {
+			err = func() error {
+				reader, err := src.ReadBatches(ctx)
+				if err != nil {
+					return err
+				}
+				defer reader.Close()
+				writer, _ := dst.WriteBatches(ctx)
+				stream := validator.New(rules).Stream()
+				if mode == "duplicates" {
+					duplicates, err := reader.(connectors.DuplicateFinder).FirstDuplicates(ctx, rules.Unique)
+					if err != nil {
+						return err
+					}
+					stream = validator.New(rules).StreamDuplicates(duplicates)
+				}
+				for {
+					batch, err := reader.Next(ctx)
+					if errors.Is(err, io.EOF) {
+						return writer.Commit(ctx)
+					}
+					if err != nil {
+						return err
+					}
+					if err == nil {
+						err = stream.ValidateRecord(ctx, batch)
+					}
+					if err == nil {
+						err = writer.Write(ctx, batch)
+					}
+					batch.Release()
+					if err != nil {
+						writer.Abort()
+						return err
+					}
+				}
+			}()
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dest, "*", "*"))
+		if len(files) == 0 {
+			return nil, err
+		}
+		if len(files) > 1 || filepath.Ext(files[0]) != ".parquet" {
+			t.Fatalf("Destination holds %v, want one parquet file", files)
+		}
+		out, rerr := New(files[0], "daily", fields)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		defer out.Close()
+		rows, rerr := out.Read(ctx)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		return rows, err
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantRows, wantErr := load(t, tt.csv, tt.rules, "stream")
+			gotRows, gotErr := load(t, tt.csv, tt.rules, "direct")
+			if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+				t.Errorf("LoadFrom() error = %v, want %v", gotErr, wantErr)
+			}
+			dupRows, dupErr := load(t, tt.csv, tt.rules, "duplicates")
+			if fmt.Sprint(dupErr) != fmt.Sprint(wantErr) || fmt.Sprint(dupRows) != fmt.Sprint(wantRows) {
+				t.Errorf("FirstDuplicates() stream wrote %v with error %v, want %v with %v", dupRows, dupErr, wantRows, wantErr)
+			}
+			var invalid *validator.Error
+			if wantErr != nil && !errors.As(gotErr, &invalid) {
+				t.Errorf("LoadFrom() error = %T, want a *validator.Error", gotErr)
+			}
+			if fmt.Sprint(gotRows) != fmt.Sprint(wantRows) {
+				t.Errorf("LoadFrom() wrote %v, want %v", gotRows, wantRows)
+			}
+		})
+	}
+
+	// Other connectors are streamed
+	fc, err := New(t.TempDir(), "daily", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fc.Close()
+	if _, err := fc.LoadFrom(context.Background(), nil, parser.ValidationConfig{}); !errors.Is(err, connectors.ErrNotLoadable) {
+		t.Errorf("LoadFrom() error = %v, want ErrNotLoadable", err)
+	}
+}
+
+func TestLoadFromEmptyAck(t *testing.T) {
+	fields := []parser.FieldConfig{{Label: "id", DataType: "int"}}
+
+	// Both paths process a directory of empty files once they are acked
+	for _, direct := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Direct %v", direct), func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "raw")
+			dest := filepath.Join(dir, "out")
+			for _, d := range []string{source, dest} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(source, "empty.csv"), []byte("id\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src, err := New(source, "daily", fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			dst, err := New(dest, "daily", fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+
+			ctx := context.Background()
+			if direct {
+				_, err = dst.LoadFrom(ctx, src, parser.ValidationConfig{})
+			} else {
+				var data []map[string]any
+				if data, err = src.Read(ctx); err == nil {
+					err = dst.Write(ctx, data)
+				}
+			}
+			if err != nil {
+				t.Fatalf("Load error = %v", err)
+			}
+			if err := src.Ack(ctx); err != nil {
+				t.Fatalf("Ack() error = %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(source, "empty.csv")); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Empty source file was not deleted: %v", err)
+			}
+			files, _ := filepath.Glob(filepath.Join(dest, "*", "*"))
+			if len(files) != 0 {
+				t.Errorf("Destination holds %v, want nothing", files)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	fields := []parser.FieldConfig{
+		{Label: "id", DataType: "int"},
+		{Label: "name", DataType: "string"},
+	}
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw")
+	if err := os.MkdirAll(raw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(raw, "in.csv"), []byte("id,name\n1,a\n2,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := New(raw, "daily", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dest := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := New(dest, "daily", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	// A writer cancelled before commit leaves no partial file
+	ctx, cancel := context.WithCancel(context.Background())
+	writer, err := dst.WriteBatches(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := connectors.NewRecord(dst.schema, []map[string]any{{"id": 1, "name": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Release()
+	if err := writer.Write(ctx, batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	cancel()
+	if err := writer.Commit(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Commit() error = %v, want context.Canceled", err)
+	}
+	if err := writer.Abort(); err != nil {
+		t.Errorf("Abort() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("Destination holds %v after cancel, want nothing", entries)
+	}
+
+	// A cancelled load writes nothing and keeps its source files
+	if _, err := dst.LoadFrom(ctx, src, parser.ValidationConfig{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("LoadFrom() error = %v, want context.Canceled", err)
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("Destination holds %v after cancel, want nothing", entries)
+	}
+	if _, err := os.Stat(filepath.Join(raw, "in.csv")); err != nil {
+		t.Errorf("Source file removed by a cancelled load: %v", err)
+	}
+
+	// as does a cancelled read
+	if _, err := src.Read(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(raw, "in.csv")); err != nil {
+		t.Errorf("Source file removed by a cancelled read: %v", err)
+	}
+}
+
+func TestAck(t *testing.T) {
+	fields := []parser.FieldConfig{{Label: "id", DataType: "int"}}
+	partition := time.Now().UTC().Format("2006-01-02")
+
+	tests := []struct {
+		policy string
+		// remain are the files left in the source directory after Ack
+		remain []string
+	}{
+		{policy: PROCESSED_DELETE},
+		{policy: PROCESSED_ARCHIVE, remain: []string{
+			filepath.Join(archiveDir, partition, "a.csv"),
+			filepath.Join(archiveDir, partition, "sub", "b.csv"),
+		}},
+		{policy: PROCESSED_LEAVE, remain: []string{"a.csv", filepath.Join("sub", "b.csv")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, csv := range map[string]string{"a.csv": "id\n1\n", filepath.Join("sub", "b.csv"): "id\n2\n"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(csv), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fc, err := New(dir, "daily", fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fc.Close()
+			fc.Processed = tt.policy
+			ctx := context.Background()
+
+			// Files that are read but not acked, as when a job fails, are
+			// left to be read again
+			for range 2 {
+				data, err := fc.Read(ctx)
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if len(data) != 2 {
+					t.Fatalf("Read() got %d rows, want 2", len(data))
+				}
+			}
+
+			if err := fc.Ack(ctx); err != nil {
+				t.Fatalf("Ack() error = %v", err)
+			}
+			var remain []string
+			filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && filepath.Ext(path) == ".csv" {
+					rel, _ := filepath.Rel(dir, path)
+					remain = append(remain, rel)
+				}
+				return err
+			})
+			slices.Sort(remain)
+			if !slices.Equal(remain, tt.remain) {
+				t.Errorf("Source holds %v after Ack, want %v", remain, tt.remain)
+			}
+
+			// Processed files are not read again
+			data, err := fc.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(data) != 0 {
+				t.Errorf("Read() after Ack got %d rows, want 0", len(data))
+			}
+
+			// A file replaced after it was processed is read again
+			if err := os.WriteFile(filepath.Join(dir, "a.csv"), []byte("id\n3\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(filepath.Join(dir, "a.csv"), later, later); err != nil {
+				t.Fatal(err)
+			}
+			data, err = fc.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(data) != 1 || data[0]["id"] != int32(3) {
+				t.Errorf("Read() after replacing a file got %v, want id 3", data)
+			}
+		})
+	}
+}
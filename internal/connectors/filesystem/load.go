@@ -0,0 +1,251 @@
+package filesystem
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andrew-a-hale/mdf/internal/connectors"
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/andrew-a-hale/mdf/internal/validator"
+)
+
+// validationFailed is raised by the copy query when a row breaks a rule
+const validationFailed = "mdf: validation failed"
+
+// LoadFrom loads another filesystem connector in a single DuckDB query, which
+// reads its files, casts them to the declared fields, checks the validation
+// rules and copies the rows to a Parquet file in the current partition. It
+// gives the same result as streaming the batches, without the rows leaving
+// DuckDB.
+func (fc *FilesystemConnector) LoadFrom(ctx context.Context, source connectors.Connector, rules parser.ValidationConfig) (int, error) {
+	src, ok := source.(*FilesystemConnector)
+	if !ok {
+		return 0, connectors.ErrNotLoadable
+	}
+
+	files, dir, err := src.sourceFiles(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		slog.Info("No data to write")
+		return 0, nil
+	}
+
+	// Temporary views only exist on the connection that created them
+	conn, err := fc.db.Conn(ctx)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		return 0, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close()
+
+	query, drop, err := src.unionQuery(ctx, conn, files)
+	if err != nil {
+		return 0, err
+	}
+	defer drop()
+
+	columns, err := queryColumns(ctx, conn, orderedQuery(query))
+	if err != nil {
+		return 0, err
+	}
+
+	// A not null field missing from the source fails on the first row, a
+	// missing unique field is skipped
+	v := validator.New(rules)
+	notNull := make(map[string]int)
+	for _, field := range rules.NotNull {
+		if !slices.Contains(columns, field) {
+			notNull[field] = 0
+		}
+	}
+	if len(notNull) > 0 {
+		var count int
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM (%s)", query)).Scan(&count); err != nil {
+			slog.Error("Failed to count rows", "error", err)
+			return 0, fmt.Errorf("failed to count rows: %w", err)
+		}
+		if count > 0 {
+			return 0, v.FirstError(notNull, nil, src.BatchSize)
+		}
+	}
+	var present, unique []string
+	for _, field := range rules.NotNull {
+		if slices.Contains(columns, field) {
+			present = append(present, field)
+		}
+	}
+	for _, field := range rules.Unique {
+		if slices.Contains(columns, field) && !slices.Contains(unique, field) {
+			unique = append(unique, field)
+		}
+	}
+
+	partitionName, partitionPath, err := fc.partitionFile()
+	if err != nil {
+		return 0, err
+	}
+	partitionDir := filepath.Dir(partitionPath)
+	if err := os.MkdirAll(partitionDir, 0755); err != nil {
+		slog.Error("Failed to create partition directory", "dir", partitionDir, "error", err)
+		return 0, fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	// Write the data to a temporary Parquet file using DuckDB's COPY statement,
+	// then move it into place so readers never see a partial file
+	tmpPath := partitionPath + ".tmp"
+	copySQL := fmt.Sprintf("COPY (%s) TO '%s' (FORMAT PARQUET)", checkedQuery(query, columns, present, unique), tmpPath)
+	res, err := conn.ExecContext(ctx, copySQL)
+	if err != nil {
+		os.Remove(tmpPath)
+		os.Remove(partitionDir) // only when empty
+		if strings.Contains(err.Error(), validationFailed) {
+			return 0, violations(ctx, conn, v, query, present, unique, src.BatchSize)
+		}
+		slog.Error("Failed to write data to Parquet file", "path", partitionPath, "error", err)
+		return 0, fmt.Errorf("failed to write data to Parquet file: %w", err)
+	}
+	records, err := res.RowsAffected()
+	if err != nil || records == 0 {
+		os.Remove(tmpPath)
+		os.Remove(partitionDir)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count written rows: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		// Empty files were read all the same, as when streaming the batches
+		if dir {
+			src.unacked = files
+			slog.Info("Read from directory", "dir", src.BasePath, "files", len(files), "records", 0)
+		}
+		slog.Info("No data to write")
+		return 0, nil
+	}
+	// Nothing is committed once cancelled
+	if err := ctx.Err(); err != nil {
+		os.Remove(tmpPath)
+		os.Remove(partitionDir)
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, partitionPath); err != nil {
+		os.Remove(tmpPath)
+		slog.Error("Failed to move Parquet file into place", "path", partitionPath, "error", err)
+		return 0, fmt.Errorf("failed to move Parquet file into place: %w", err)
+	}
+
+	// The source files are processed when the source is acked
+	if dir {
+		src.unacked = files
+		slog.Info("Read from directory", "dir", src.BasePath, "files", len(files), "records", records)
+	}
+
+	slog.Info("Wrote data to partitioned file", "path", partitionPath, "records", records, "partition", partitionName)
+	return int(records), nil
+}
+
+// queryColumns returns the column names of a query
+func queryColumns(ctx context.Context, conn *sql.Conn, query string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (%s) LIMIT 0", query))
+	if err != nil {
+		slog.Error("Failed to query files", "query", query, "error", err)
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// checkedQuery returns the rows of a union query in rowOrder, failing with
+// validationFailed when a row breaks a rule. Unique fields are checked by
+// numbering the rows of each value in that order.
+func checkedQuery(union string, columns, notNull, unique []string) string {
+	var checks []string
+	for _, field := range notNull {
+		checks = append(checks, fmt.Sprintf("%s IS NULL", field))
+	}
+	if len(checks) == 0 && len(unique) == 0 {
+		return orderedQuery(union)
+	}
+
+	numbered := union
+	if len(unique) > 0 {
+		windows := make([]string, 0, len(unique))
+		for i, field := range unique {
+			windows = append(windows, fmt.Sprintf("row_number() OVER (PARTITION BY %s ORDER BY %s) AS mdf_unique_%d", field, rowOrder, i))
+			checks = append(checks, fmt.Sprintf("mdf_unique_%d > 1", i))
+		}
+		numbered = fmt.Sprintf("SELECT *, %s FROM (%s)", strings.Join(windows, ", "), union)
+	}
+	return fmt.Sprintf("SELECT %s FROM (%s) WHERE CASE WHEN %s THEN error('%s') ELSE true END ORDER BY %s",
+		strings.Join(columns, ", "), numbered, strings.Join(checks, " OR "), validationFailed, rowOrder)
+}
+
+// violations finds the first row breaking each rule in a union query and
+// returns the error the validator reports for them
+func violations(ctx context.Context, conn *sql.Conn, v *validator.Validator, union string, notNull, unique []string, batchSize int) error {
+	firstNull, firstDuplicate, err := firstRows(ctx, conn, union, notNull, unique)
+	if err != nil {
+		return err
+	}
+	if err := v.FirstError(firstNull, firstDuplicate, batchSize); err != nil {
+		return err
+	}
+	return errors.New("validation failed but no row breaks a rule")
+}
+
+// firstRows returns the first null row of each not null field and the first
+// repeated row of each unique field in a union query, keyed by field. Rows
+// are numbered in rowOrder, as they are read, and fields without such a row
+// are left out.
+func firstRows(ctx context.Context, conn *sql.Conn, union string, notNull, unique []string) (map[string]int, map[string]int, error) {
+	var cols []string
+	for _, field := range notNull {
+		cols = append(cols, fmt.Sprintf("min(mdf_row) FILTER (WHERE %s IS NULL)", field))
+	}
+	var windows []string
+	for i, field := range unique {
+		windows = append(windows, fmt.Sprintf("row_number() OVER (PARTITION BY %s ORDER BY mdf_row) AS mdf_unique_%d", field, i))
+		cols = append(cols, fmt.Sprintf("min(mdf_row) FILTER (WHERE mdf_unique_%d > 1)", i))
+	}
+	firstNull := make(map[string]int)
+	firstDuplicate := make(map[string]int)
+	if len(cols) == 0 {
+		return firstNull, firstDuplicate, nil
+	}
+	numbered := fmt.Sprintf("SELECT row_number() OVER (ORDER BY %s) - 1 AS mdf_row, * FROM (%s)", rowOrder, union)
+	if len(windows) > 0 {
+		numbered = fmt.Sprintf("SELECT *, %s FROM (%s)", strings.Join(windows, ", "), numbered)
+	}
+
+	rows := make([]sql.NullInt64, len(cols))
+	dest := make([]any, len(cols))
+	for i := range rows {
+		dest[i] = &rows[i]
+	}
+	rowsSQL := fmt.Sprintf("SELECT %s FROM (%s)", strings.Join(cols, ", "), numbered)
+	if err := conn.QueryRowContext(ctx, rowsSQL).Scan(dest...); err != nil {
+		slog.Error("Failed to find rows breaking validation rules", "error", err)
+		return nil, nil, fmt.Errorf("failed to find rows breaking validation rules: %w", err)
+	}
+
+	for i, row := range rows {
+		if !row.Valid {
+			continue
+		}
+		if i < len(notNull) {
+			firstNull[notNull[i]] = int(row.Int64)
+		} else {
+			firstDuplicate[unique[i-len(notNull)]] = int(row.Int64)
+		}
+	}
+	return firstNull, firstDuplicate, nil
+}
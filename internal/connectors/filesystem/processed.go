@@ -0,0 +1,151 @@
+package filesystem
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// archiveDir is the directory of a source that processed files are
+	// archived to, it is never read
+	archiveDir = "archive"
+	// registryFile lists the files of a source directory that were loaded and
+	// left in place
+	registryFile = ".mdf_processed"
+)
+
+// Ack applies the processed policy to the files read from a directory since
+// the last Ack. It is called once the files are committed to the destination,
+// a job that fails leaves them untouched to be read again.
+func (fc *FilesystemConnector) Ack(ctx context.Context) error {
+	files := fc.unacked
+	fc.unacked = nil
+	if len(files) == 0 {
+		return nil
+	}
+
+	var errs []error
+	switch fc.Processed {
+	case PROCESSED_ARCHIVE:
+		errs = fc.archive(files)
+	case PROCESSED_LEAVE:
+		if err := fc.register(files); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		for _, filePath := range files {
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove processed file: %w", err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("Failed to process source files", "dir", fc.BasePath, "policy", fc.Processed, "error", err)
+		return err
+	}
+
+	slog.Info("Processed source files", "dir", fc.BasePath, "policy", fc.Processed, "files", len(files))
+	return nil
+}
+
+// archive moves files to archive/<partition>/ in the source directory,
+// keeping their path relative to it. A file archived under the same name
+// earlier in the partition is kept, the new file gets a unique suffix.
+func (fc *FilesystemConnector) archive(files []string) []error {
+	partitionDir := filepath.Join(fc.BasePath, archiveDir, fc.partitionName(time.Now().UTC()))
+
+	var errs []error
+	for _, filePath := range files {
+		rel, err := filepath.Rel(fc.BasePath, filePath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to archive %s: %w", filePath, err))
+			continue
+		}
+		target := filepath.Join(partitionDir, rel)
+		if _, err := os.Stat(target); err == nil {
+			ext := filepath.Ext(target)
+			target = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(target, ext), uuid.New().String(), ext)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create archive directory: %w", err))
+			continue
+		}
+		if err := os.Rename(filePath, target); err != nil {
+			errs = append(errs, fmt.Errorf("failed to archive %s: %w", filePath, err))
+		}
+	}
+	return errs
+}
+
+// register appends files to the processed registry of the source directory
+func (fc *FilesystemConnector) register(files []string) error {
+	f, err := os.OpenFile(filepath.Join(fc.BasePath, registryFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open processed registry: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, filePath := range files {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to register processed file: %w", err)
+		}
+		entry, err := fc.registryEntry(filePath, info)
+		if err != nil {
+			return fmt.Errorf("failed to register processed file: %w", err)
+		}
+		fmt.Fprintln(w, entry)
+		fc.ProcessedFiles[entry] = true
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write processed registry: %w", err)
+	}
+	return nil
+}
+
+// loadRegistry reads the processed registry of the source directory into
+// ProcessedFiles, a missing registry is empty
+func (fc *FilesystemConnector) loadRegistry() error {
+	fc.ProcessedFiles = make(map[string]bool)
+	f, err := os.Open(filepath.Join(fc.BasePath, registryFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open processed registry: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry := scanner.Text(); entry != "" {
+			fc.ProcessedFiles[entry] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read processed registry: %w", err)
+	}
+	return nil
+}
+
+// registryEntry identifies a file in the processed registry by its path in
+// the source directory and its modification time, so a file that is replaced
+// is read again
+func (fc *FilesystemConnector) registryEntry(filePath string, info fs.FileInfo) (string, error) {
+	rel, err := filepath.Rel(fc.BasePath, filePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\t%s", info.ModTime().UTC().Format(time.RFC3339Nano), filepath.ToSlash(rel)), nil
+}
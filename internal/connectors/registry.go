@@ -0,0 +1,120 @@
+package connectors
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// Role identifies which side of a job a connector is used for
+type Role string
+
+const (
+	SOURCE      Role = "source"
+	DESTINATION Role = "destination"
+)
+
+// Options carries the job context a connector is constructed with
+type Options struct {
+	Role       Role
+	DataSource parser.DataSource
+	// WorkDir is the root that relative connector paths are resolved against,
+	// the process working directory is used when empty
+	WorkDir string
+}
+
+// Registration describes how to build a connector of a given type
+type Registration struct {
+	// NewSettings returns the connector's typed settings populated with
+	// defaults, which the parser decodes the YAML settings into
+	NewSettings func() parser.ConnectorSettings
+
+	// New creates a connector from its decoded settings
+	New func(settings parser.ConnectorSettings, opts Options) (Connector, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Registration)
+)
+
+// Register makes a connector type available by name, including its settings
+// to the parser. It panics if the name is already registered or the
+// registration is incomplete.
+func Register(name string, reg Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if reg.NewSettings == nil || reg.New == nil {
+		panic(fmt.Sprintf("connectors: incomplete registration for connector type %q", name))
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("connectors: connector type %q registered twice", name))
+	}
+	registry[name] = reg
+	parser.RegisterConnectorType(name, reg.NewSettings)
+}
+
+// Lookup returns the registration for a connector type
+func Lookup(name string) (Registration, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	reg, ok := registry[name]
+	if !ok {
+		return Registration{}, fmt.Errorf("unknown connector type %q, must be one of: %v", name, types())
+	}
+	return reg, nil
+}
+
+// Types returns the names of all registered connector types
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return types()
+}
+
+func types() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open resolves the named connector entry of a config through the registry
+// and constructs it for the given role
+func Open(config parser.Config, name string, role Role, workDir string) (Connector, error) {
+	cc, ok := config.Connectors[name]
+	if !ok {
+		return nil, fmt.Errorf("connector %q is not declared in connectors, must be one of: %v", name, declared(config))
+	}
+
+	if cc.Type == "" {
+		return nil, fmt.Errorf("connector %q has no type", name)
+	}
+
+	reg, err := Lookup(cc.Type)
+	if err != nil {
+		return nil, fmt.Errorf("connector %q: %w", name, err)
+	}
+
+	if cc.Settings == nil {
+		return nil, fmt.Errorf("connector %q has no %s settings", name, cc.Type)
+	}
+
+	return reg.New(cc.Settings, Options{Role: role, DataSource: config.DataSource, WorkDir: workDir})
+}
+
+// declared returns the sorted connector names declared in a config
+func declared(config parser.Config) []string {
+	names := make([]string, 0, len(config.Connectors))
+	for name := range config.Connectors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
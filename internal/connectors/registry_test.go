@@ -0,0 +1,105 @@
+package connectors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// stubConnector is a no-op connector for registry tests
+type stubConnector struct {
+	settings *stubSettings
+	opts     Options
+}
+
+// stubSettings are the settings of a stubConnector
+type stubSettings struct {
+	Path string `yaml:"path"`
+}
+
+func (s *stubSettings) Validate() error { return nil }
+
+func (s *stubConnector) Read(context.Context) ([]map[string]any, error) { return nil, nil }
+func (s *stubConnector) Write(context.Context, []map[string]any) error  { return nil }
+func (s *stubConnector) Close() error                                   { return nil }
+
+func init() {
+	Register("stub", Registration{
+		NewSettings: func() parser.ConnectorSettings { return &stubSettings{} },
+		New: func(settings parser.ConnectorSettings, opts Options) (Connector, error) {
+			return &stubConnector{settings: settings.(*stubSettings), opts: opts}, nil
+		},
+	})
+}
+
+func TestOpen(t *testing.T) {
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"in":       {Type: "stub", Settings: &stubSettings{Path: "landing"}},
+			"unknown":  {Type: "nope"},
+			"untyped":  {Settings: &stubSettings{}},
+			"settings": {Type: "stub"},
+		},
+		DataSource: parser.DataSource{Domain: "test"},
+	}
+
+	c, err := Open(config, "in", SOURCE, "")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	stub := c.(*stubConnector)
+	if stub.settings.Path != "landing" {
+		t.Errorf("settings path = %v, want landing", stub.settings.Path)
+	}
+	if stub.opts.Role != SOURCE || stub.opts.DataSource.Domain != "test" {
+		t.Errorf("unexpected options: %+v", stub.opts)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"missing", "not declared"},
+		{"unknown", "unknown connector type"},
+		{"untyped", "has no type"},
+		{"settings", "has no stub settings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(config, tt.name, DESTINATION, "")
+			if err == nil {
+				t.Fatal("Open() should return error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() with duplicate name should panic")
+		}
+	}()
+	Register("stub", Registration{
+		NewSettings: func() parser.ConnectorSettings { return &stubSettings{} },
+		New:         func(parser.ConnectorSettings, Options) (Connector, error) { return nil, nil },
+	})
+}
+
+func TestRegisterSettings(t *testing.T) {
+	// Registered settings are decoded by the parser
+	settings, ok := parser.NewConnectorSettings("stub")
+	if !ok {
+		t.Fatal("stub settings should be registered with the parser")
+	}
+	if _, ok := settings.(*stubSettings); !ok {
+		t.Errorf("Expected *stubSettings, got %T", settings)
+	}
+}
@@ -1,31 +1,72 @@
 package executor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"path/filepath"
 	"time"
 
 	"github.com/andrew-a-hale/mdf/internal/connectors"
-	"github.com/andrew-a-hale/mdf/internal/connectors/filesystem"
+	_ "github.com/andrew-a-hale/mdf/internal/connectors/filesystem" // Import for side effect of registering connector
 	"github.com/andrew-a-hale/mdf/internal/parser"
 	"github.com/andrew-a-hale/mdf/internal/validator"
+	"github.com/apache/arrow-go/v18/arrow"
 )
 
+// Error is a failed stage of a job, classified so retry policies can tell
+// connector failures from data that failed validation
+type Error struct {
+	// Class is parser.CONNECTOR_ERROR or parser.VALIDATION_ERROR
+	Class string
+	Err   error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrTimeout is the cause of a job cancelled at the timeout of its data
+// source
+var ErrTimeout = errors.New("job timed out")
+
+// Class returns the class of a job error, or an empty string when the error
+// is not classified, such as a cancelled job
+func Class(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	return ""
+}
+
 // Executor handles the execution of data ingestion jobs
 type Executor struct {
 	Config parser.Config
+	// WorkDir is the root that relative connector paths are resolved against
+	WorkDir string
 }
 
-// New creates a new executor instance
+// New creates a new executor instance, relative connector paths are resolved
+// against the process working directory until WorkDir is set
 func New(config parser.Config) *Executor {
 	return &Executor{
 		Config: config,
 	}
 }
 
-// Execute runs the data ingestion job
-func (e *Executor) Execute() error {
+// Execute runs the data ingestion job, streaming the source to the
+// destination in batches. A cancelled ctx stops the job, interrupting the
+// connector call in progress, and nothing is committed once it is
+// cancelled. A job running past the timeout of its data source is cancelled
+// the same way and fails with a connector error.
+func (e *Executor) Execute(ctx context.Context) error {
+	if timeout := e.Config.DataSource.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrTimeout)
+		defer cancel()
+	}
+
 	// Log the execution start with timestamp
 	start := time.Now()
 	jobID := fmt.Sprintf("%s-%s-%d", e.Config.DataSource.Domain, e.Config.DataSource.Name, start.Unix())
@@ -36,66 +77,43 @@ func (e *Executor) Execute() error {
 		"job_id", jobID)
 
 	// Get source connector
-	var err error
-	var sourceConnecter connectors.Connector
-	switch e.Config.Connectors["source"].(map[string]any)["type"] {
-	case connectors.FILESYSTEM:
-		sourceConnecter, err = filesystem.New(
-			filepath.Join("raw", e.Config.DataSource.Domain),
-			e.Config.Connectors["source"].(map[string]any)["partition"].(string),
-			e.Config.DataSource.Fields,
-		)
-		if err != nil {
-			slog.Error("failed to initialise source connector", "error", err)
-			return fmt.Errorf("failed to initialise source connector: %v", err)
-		}
-	default:
+	sourceConnecter, err := connectors.Open(e.Config, e.Config.DataSource.Source.Connector, connectors.SOURCE, e.WorkDir)
+	if err != nil {
 		slog.Error("failed to initialise source connector", "error", err)
-		return fmt.Errorf("failed to initialise source connector: %v", err)
+		return &Error{Class: parser.CONNECTOR_ERROR, Err: fmt.Errorf("failed to initialise source connector: %w", err)}
 	}
 	defer sourceConnecter.Close()
 
 	// Get destination connector
-	var destConnecter connectors.Connector
-	switch e.Config.Connectors["destination"].(map[string]any)["type"] {
-	case connectors.FILESYSTEM:
-		destConnecter, err = filesystem.New(
-			filepath.Join("ingested", e.Config.DataSource.Domain),
-			e.Config.Connectors["destination"].(map[string]any)["partition"].(string),
-			e.Config.DataSource.Fields,
-		)
-		if err != nil {
-			slog.Error("failed to initialise destination connector", "error", err)
-			return fmt.Errorf("failed to initialise destination connector: %v", err)
-		}
-	default:
+	destConnecter, err := connectors.Open(e.Config, e.Config.DataSource.Destination.Connector, connectors.DESTINATION, e.WorkDir)
+	if err != nil {
 		slog.Error("failed to initialise destination connector", "error", err)
-		return fmt.Errorf("failed to initialise destination connector: %v", err)
+		return &Error{Class: parser.CONNECTOR_ERROR, Err: fmt.Errorf("failed to initialise destination connector: %w", err)}
 	}
 	defer destConnecter.Close()
 
 	// Extract data from source
-	data, err := sourceConnecter.Read()
-	if err != nil {
-		slog.Error("Failed to extract data",
-			"error", err,
-			"source", e.Config.DataSource.Source.FQNResource)
+	if err := e.cancelled(ctx, "extract"); err != nil {
 		return err
 	}
 
-	// Validate the data
-	validator := validator.New(e.Config.DataSource.Validate)
-	err = validator.Validate(data)
+	// Load the source in one step when the destination can, and stream it in
+	// batches otherwise
+	records, err := e.loadDirect(ctx, sourceConnecter, destConnecter)
+	if errors.Is(err, connectors.ErrNotLoadable) {
+		records, err = e.loadBatches(ctx, sourceConnecter, destConnecter)
+	}
 	if err != nil {
-		slog.Error("Validation failed", "error", err)
 		return err
 	}
 
-	// Load data to destination
-	err = destConnecter.Write(data)
-	if err != nil {
-		slog.Error("Failed to load data", "error", err)
-		return err
+	// The source is only processed once the load is committed, it is not
+	// cancelled part way. The data is loaded even when this fails, so the job
+	// still succeeds.
+	if acker, ok := sourceConnecter.(connectors.Acker); ok {
+		if err := acker.Ack(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("Failed to acknowledge source", "error", err)
+		}
 	}
 
 	// TODO: Add eventlog and notifier
@@ -105,10 +123,194 @@ func (e *Executor) Execute() error {
 	slog.Info("Job completed",
 		"domain", e.Config.DataSource.Domain,
 		"name", e.Config.DataSource.Name,
-		"records", len(data),
+		"records", records,
 		"time", end.Format(time.RFC3339),
 		"duration_ms", duration.Milliseconds(),
 		"job_id", jobID)
 
 	return nil
 }
+
+// loadDirect loads the source through the destination when it implements
+// connectors.Loader, and returns connectors.ErrNotLoadable otherwise
+func (e *Executor) loadDirect(ctx context.Context, source, dest connectors.Connector) (int, error) {
+	loader, ok := dest.(connectors.Loader)
+	if !ok {
+		return 0, connectors.ErrNotLoadable
+	}
+
+	records, err := loader.LoadFrom(ctx, source, e.Config.DataSource.Validate)
+	if err == nil || errors.Is(err, connectors.ErrNotLoadable) {
+		return records, err
+	}
+	if err := e.cancelled(ctx, "load"); err != nil {
+		return 0, err
+	}
+	var invalid *validator.Error
+	if errors.As(err, &invalid) {
+		slog.Error("Validation failed", "error", err)
+		return 0, &Error{Class: parser.VALIDATION_ERROR, Err: err}
+	}
+	slog.Error("Failed to load data", "error", err)
+	return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+}
+
+// loadBatches streams the source to the destination in batches
+func (e *Executor) loadBatches(ctx context.Context, source, dest connectors.Connector) (int, error) {
+	schema, err := connectors.Schema(e.Config.DataSource.Fields)
+	if err != nil {
+		slog.Error("Invalid fields", "error", err)
+		return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+	reader, err := connectors.Batches(source, schema).ReadBatches(ctx)
+	if err != nil {
+		if err := e.cancelled(ctx, "extract"); err != nil {
+			return 0, err
+		}
+		slog.Error("Failed to extract data",
+			"error", err,
+			"source", e.Config.DataSource.Source.FQNResource)
+		return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+	defer reader.Close()
+
+	writer, err := connectors.Batches(dest, schema).WriteBatches(ctx)
+	if err != nil {
+		if err := e.cancelled(ctx, "load"); err != nil {
+			return 0, err
+		}
+		slog.Error("Failed to load data", "error", err)
+		return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+
+	records, err := e.stream(ctx, reader, writer)
+	if err != nil {
+		abort(writer)
+		return 0, err
+	}
+
+	// Commit the loaded batches
+	if err := e.cancelled(ctx, "commit"); err != nil {
+		abort(writer)
+		return 0, err
+	}
+	if err := writer.Commit(ctx); err != nil {
+		abort(writer)
+		if err := e.cancelled(ctx, "commit"); err != nil {
+			return 0, err
+		}
+		slog.Error("Failed to load data", "error", err)
+		return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+
+	return records, nil
+}
+
+// abort discards the batches written so far, a failure is logged as it may
+// leave a temporary file behind
+func abort(writer connectors.BatchWriter) {
+	if err := writer.Abort(); err != nil {
+		slog.Warn("Failed to abort load", "error", err)
+	}
+}
+
+// validator returns the stream validating the batches of a reader. Readers
+// that find repeated values themselves check the unique fields up front, so
+// the stream does not hold the values read.
+func (e *Executor) validator(ctx context.Context, reader connectors.BatchReader) (*validator.Stream, error) {
+	rules := e.Config.DataSource.Validate
+	v := validator.New(rules)
+	finder, ok := reader.(connectors.DuplicateFinder)
+	if !ok || len(rules.Unique) == 0 {
+		return v.Stream(), nil
+	}
+
+	duplicates, err := finder.FirstDuplicates(ctx, rules.Unique)
+	if err != nil {
+		if err := e.cancelled(ctx, "validate"); err != nil {
+			return nil, err
+		}
+		slog.Error("Failed to find duplicate values", "error", err)
+		return nil, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+	return v.StreamDuplicates(duplicates), nil
+}
+
+// stream validates and loads each batch read from the source, so only one
+// batch is held in memory at a time. It returns the number of records loaded.
+func (e *Executor) stream(ctx context.Context, reader connectors.BatchReader, writer connectors.BatchWriter) (int, error) {
+	validator, err := e.validator(ctx, reader)
+	if err != nil {
+		return 0, err
+	}
+	records := 0
+	for {
+		batch, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			if err := e.cancelled(ctx, "extract"); err != nil {
+				return records, err
+			}
+			slog.Error("Failed to extract data",
+				"error", err,
+				"source", e.Config.DataSource.Source.FQNResource)
+			return records, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+		}
+
+		n, err := e.load(ctx, validator, writer, batch)
+		batch.Release()
+		if err != nil {
+			return records, err
+		}
+		records += n
+	}
+}
+
+// load validates a batch and writes it to the destination
+func (e *Executor) load(ctx context.Context, validator *validator.Stream, writer connectors.BatchWriter, batch arrow.Record) (int, error) {
+	// Validate the data
+	if err := validator.ValidateRecord(ctx, batch); err != nil {
+		if err := e.cancelled(ctx, "validate"); err != nil {
+			return 0, err
+		}
+		slog.Error("Validation failed", "error", err)
+		return 0, &Error{Class: parser.VALIDATION_ERROR, Err: err}
+	}
+
+	// Load data to destination
+	if err := e.cancelled(ctx, "load"); err != nil {
+		return 0, err
+	}
+	if err := writer.Write(ctx, batch); err != nil {
+		if err := e.cancelled(ctx, "load"); err != nil {
+			return 0, err
+		}
+		slog.Error("Failed to load data", "error", err)
+		return 0, &Error{Class: parser.CONNECTOR_ERROR, Err: err}
+	}
+	return int(batch.NumRows()), nil
+}
+
+// cancelled returns an error when ctx is done before a stage of the job. A
+// timed out job is a connector error, so retry policies can retry it.
+func (e *Executor) cancelled(ctx context.Context, stage string) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(context.Cause(ctx), ErrTimeout) {
+		slog.Warn("Job timed out",
+			"domain", e.Config.DataSource.Domain,
+			"name", e.Config.DataSource.Name,
+			"stage", stage,
+			"timeout", e.Config.DataSource.Timeout.String())
+		return &Error{Class: parser.CONNECTOR_ERROR, Err: fmt.Errorf("%w after %s before %s: %w", ErrTimeout, e.Config.DataSource.Timeout, stage, err)}
+	}
+	slog.Warn("Job cancelled",
+		"domain", e.Config.DataSource.Domain,
+		"name", e.Config.DataSource.Name,
+		"stage", stage)
+	return fmt.Errorf("job cancelled before %s: %w", stage, err)
+}
@@ -1,45 +1,78 @@
 package executor
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/andrew-a-hale/mdf/internal/connectors"
+	"github.com/andrew-a-hale/mdf/internal/connectors/filesystem"
 	"github.com/andrew-a-hale/mdf/internal/parser"
 )
 
 // MockConnector is a mock implementation of the Connector interface for testing
 type MockConnector struct {
-	readFunc  func() ([]map[string]any, error)
-	writeFunc func(data []map[string]any) error
+	readFunc  func(ctx context.Context) ([]map[string]any, error)
+	writeFunc func(ctx context.Context, data []map[string]any) error
 }
 
 // NewMockConnector creates a new mock connector
 func NewMockConnector() *MockConnector {
 	return &MockConnector{
-		readFunc: func() ([]map[string]any, error) {
+		readFunc: func(ctx context.Context) ([]map[string]any, error) {
 			return []map[string]any{}, nil
 		},
-		writeFunc: func(data []map[string]any) error {
+		writeFunc: func(ctx context.Context, data []map[string]any) error {
 			return nil
 		},
 	}
 }
 
 // Read implements the Connector interface
-func (m *MockConnector) Read() ([]map[string]any, error) {
+func (m *MockConnector) Read(ctx context.Context) ([]map[string]any, error) {
 	if m.readFunc != nil {
-		return m.readFunc()
+		return m.readFunc(ctx)
 	}
 	return []map[string]any{}, nil
 }
 
 // Write implements the Connector interface
-func (m *MockConnector) Write(data []map[string]any) error {
+func (m *MockConnector) Write(ctx context.Context, data []map[string]any) error {
 	if m.writeFunc != nil {
-		return m.writeFunc(data)
+		return m.writeFunc(ctx, data)
 	}
 	return nil
 }
 
+// Close implements the Connector interface
+func (m *MockConnector) Close() error { return nil }
+
+// hangingSettings are the settings of a connector whose reads hang until
+// they are cancelled
+type hangingSettings struct{}
+
+func (s *hangingSettings) Validate() error { return nil }
+
+func init() {
+	connectors.Register("hanging", connectors.Registration{
+		NewSettings: func() parser.ConnectorSettings { return &hangingSettings{} },
+		New: func(settings parser.ConnectorSettings, opts connectors.Options) (connectors.Connector, error) {
+			m := NewMockConnector()
+			m.readFunc = func(ctx context.Context) ([]map[string]any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			m.writeFunc = func(ctx context.Context, data []map[string]any) error {
+				return errors.New("nothing is written by a hanging job")
+			}
+			return m, nil
+		},
+	})
+}
+
 func TestNew(t *testing.T) {
 	// Create test data source
 	ds := parser.DataSource{
@@ -63,3 +96,277 @@ func TestNew(t *testing.T) {
 		t.Errorf("Executor has wrong data source reference")
 	}
 }
+
+func TestExecuteUnknownConnector(t *testing.T) {
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"source": {Type: "unknown"},
+		},
+		DataSource: parser.DataSource{
+			Domain:      "test",
+			Name:        "test_source",
+			Source:      parser.SourceConfig{Connector: "source"},
+			Destination: parser.DestinationConfig{Connector: "destination"},
+		},
+	}
+
+	err := New(config).Execute(context.Background())
+	if err == nil {
+		t.Fatal("Execute() with unknown connector type should return error")
+	}
+	if class := Class(err); class != parser.CONNECTOR_ERROR {
+		t.Errorf("Class() = %q, want %q", class, parser.CONNECTOR_ERROR)
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mdf-executor-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source.csv")
+	if err := os.WriteFile(source, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"fs": {Type: "filesystem", Settings: &filesystem.Config{BasePath: dir, Partition: "daily"}},
+		},
+		DataSource: parser.DataSource{
+			Domain:      "test",
+			Name:        "test_source",
+			Source:      parser.SourceConfig{Connector: "fs", FQNResource: "source.csv"},
+			Destination: parser.DestinationConfig{Connector: "fs"},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = New(config).Execute(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Execute() error = %v, want context.Canceled", err)
+	}
+	if class := Class(err); class != "" {
+		t.Errorf("Class() = %q, cancelled jobs are not classified", class)
+	}
+
+	// Nothing is read or written once cancelled
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "source.csv" {
+		t.Errorf("Directory holds %v, want only the untouched source", entries)
+	}
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"hanging": {Type: "hanging", Settings: &hangingSettings{}},
+		},
+		DataSource: parser.DataSource{
+			Domain:      "test",
+			Name:        "test_source",
+			Source:      parser.SourceConfig{Connector: "hanging"},
+			Destination: parser.DestinationConfig{Connector: "hanging"},
+			Timeout:     50 * time.Millisecond,
+			Fields:      []parser.FieldConfig{{Label: "id", DataType: "int"}},
+		},
+	}
+
+	start := time.Now()
+	err := New(config).Execute(context.Background())
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Execute() error = %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Execute() took %s, want it cancelled at the timeout", elapsed)
+	}
+
+	// Timed out jobs are retried like other connector failures
+	if class := Class(err); class != parser.CONNECTOR_ERROR {
+		t.Errorf("Class() = %q, want %q", class, parser.CONNECTOR_ERROR)
+	}
+}
+
+func TestExecuteValidationFailed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mdf-executor-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "raw"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "raw", "source.csv"), []byte("id\n1\n2\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "dest"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"source":      {Type: "filesystem", Settings: &filesystem.Config{BasePath: filepath.Join(dir, "raw"), Partition: "daily"}},
+			"destination": {Type: "filesystem", Settings: &filesystem.Config{BasePath: filepath.Join(dir, "dest"), Partition: "daily"}},
+		},
+		DataSource: parser.DataSource{
+			Domain:      "test",
+			Name:        "test_source",
+			Source:      parser.SourceConfig{Connector: "source", FQNResource: "source.csv"},
+			Destination: parser.DestinationConfig{Connector: "destination"},
+			Validate:    parser.ValidationConfig{Unique: []string{"id"}},
+			Fields:      []parser.FieldConfig{{Label: "id", DataType: "int"}},
+		},
+	}
+
+	err = New(config).Execute(context.Background())
+	if class := Class(err); class != parser.VALIDATION_ERROR {
+		t.Fatalf("Execute() error = %v, want a %s", err, parser.VALIDATION_ERROR)
+	}
+
+	// Nothing is committed when a batch fails validation
+	entries, err := os.ReadDir(filepath.Join(dir, "dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Destination holds %v, want nothing", entries)
+	}
+}
+
+func TestExecuteProcessedFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		archived bool
+	}{
+		{name: "Loaded", csv: "id\n1\n2\n", archived: true},
+		{name: "Failed", csv: "id\n1\n2\n1\n", archived: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, sub := range []string{"raw", "dest"} {
+				if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "raw", "source.csv"), []byte(tt.csv), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			config := parser.Config{
+				Id: "a",
+				Connectors: map[string]parser.ConnectorConfig{
+					"source": {Type: "filesystem", Settings: &filesystem.Config{
+						BasePath: filepath.Join(dir, "raw"), Partition: "daily", Processed: filesystem.PROCESSED_ARCHIVE,
+					}},
+					"destination": {Type: "filesystem", Settings: &filesystem.Config{BasePath: filepath.Join(dir, "dest"), Partition: "daily"}},
+				},
+				DataSource: parser.DataSource{
+					Domain:      "test",
+					Name:        "test_source",
+					Source:      parser.SourceConfig{Connector: "source"},
+					Destination: parser.DestinationConfig{Connector: "destination"},
+					Validate:    parser.ValidationConfig{Unique: []string{"id"}},
+					Fields:      []parser.FieldConfig{{Label: "id", DataType: "int"}},
+				},
+			}
+
+			err := New(config).Execute(context.Background())
+			if tt.archived && err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !tt.archived && err == nil {
+				t.Fatal("Execute() error = nil, want a validation error")
+			}
+
+			// Source files are only archived once the job has loaded them
+			archived, _ := filepath.Glob(filepath.Join(dir, "raw", "archive", "*", "source.csv"))
+			_, statErr := os.Stat(filepath.Join(dir, "raw", "source.csv"))
+			if tt.archived && (len(archived) != 1 || statErr == nil) {
+				t.Errorf("Source archived to %v, want it moved to the archive", archived)
+			}
+			if !tt.archived && (len(archived) != 0 || statErr != nil) {
+				t.Errorf("Source archived to %v, want it left in place: %v", archived, statErr)
+			}
+		})
+	}
+}
+
+func TestLoadDirectMatchesBatches(t *testing.T) {
+	// The duplicate of id 2 is in the second file, rows are numbered by file
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw")
+	if err := os.MkdirAll(raw, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.csv":   "id\n1\n2\n3\n",
+		"b.csv":   "id\n4\n5\n2\n",
+		"c.jsonl": "{\"id\": 6}\n{\"id\": 5}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(raw, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := parser.Config{
+		Id: "a",
+		Connectors: map[string]parser.ConnectorConfig{
+			"source":      {Type: "filesystem", Settings: &filesystem.Config{BasePath: raw, Partition: "daily"}},
+			"destination": {Type: "filesystem", Settings: &filesystem.Config{BasePath: filepath.Join(dir, "dest"), Partition: "daily"}},
+		},
+		DataSource: parser.DataSource{
+			Source:      parser.SourceConfig{Connector: "source"},
+			Destination: parser.DestinationConfig{Connector: "destination"},
+			Validate:    parser.ValidationConfig{NotNull: []string{"id"}, Unique: []string{"id"}},
+			Fields:      []parser.FieldConfig{{Label: "id", DataType: "int"}},
+		},
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "dest"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// load runs the job through the destination or in batches
+	load := func(direct bool) error {
+		e := New(config)
+		source, err := connectors.Open(config, "source", connectors.SOURCE, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		dest, err := connectors.Open(config, "destination", connectors.DESTINATION, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dest.Close()
+		if direct {
+			_, err = e.loadDirect(context.Background(), source, dest)
+		} else {
+			_, err = e.loadBatches(context.Background(), source, dest)
+		}
+		return err
+	}
+
+	want := "validation error: field 'id' must be unique (row 5)"
+	for range 3 {
+		batches, direct := load(false), load(true)
+		if batches == nil || batches.Error() != want {
+			t.Fatalf("loadBatches() error = %v, want %s", batches, want)
+		}
+		if direct == nil || direct.Error() != batches.Error() {
+			t.Errorf("loadDirect() error = %v, want %v", direct, batches)
+		}
+	}
+}
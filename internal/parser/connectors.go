@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConnectorSettings is the typed configuration of a connector type
+type ConnectorSettings interface {
+	// Validate reports invalid settings once defaults have been applied
+	Validate() error
+}
+
+// ConnectorConfig declares a connector and its type-specific settings, which
+// are written inline next to the type
+type ConnectorConfig struct {
+	Type     string
+	Settings ConnectorSettings
+}
+
+var (
+	connectorTypesMu sync.RWMutex
+	connectorTypes   = make(map[string]func() ConnectorSettings)
+)
+
+// RegisterConnectorType makes the settings of a connector type known to the
+// parser. newSettings returns settings populated with their defaults. It
+// panics if the name is already registered.
+func RegisterConnectorType(name string, newSettings func() ConnectorSettings) {
+	connectorTypesMu.Lock()
+	defer connectorTypesMu.Unlock()
+
+	if _, dup := connectorTypes[name]; dup {
+		panic(fmt.Sprintf("parser: connector type %q registered twice", name))
+	}
+	connectorTypes[name] = newSettings
+}
+
+// ConnectorTypes returns the names of all registered connector types
+func ConnectorTypes() []string {
+	connectorTypesMu.RLock()
+	defer connectorTypesMu.RUnlock()
+	return sortedKeys(connectorTypes)
+}
+
+// NewConnectorSettings returns the default settings of a connector type
+func NewConnectorSettings(name string) (ConnectorSettings, bool) {
+	connectorTypesMu.RLock()
+	defer connectorTypesMu.RUnlock()
+
+	newSettings, ok := connectorTypes[name]
+	if !ok {
+		return nil, false
+	}
+	return newSettings(), true
+}
+
+// UnmarshalYAML decodes the settings of a registered connector type. Missing
+// and unknown types are left without settings and reported by Validate.
+func (c *ConnectorConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: connector must be a mapping", node.Line)}}
+	}
+
+	if t := child(node, "type"); t != nil {
+		c.Type = t.Value
+	}
+	settings, ok := NewConnectorSettings(c.Type)
+	if !ok {
+		return nil
+	}
+
+	if err := withoutKey(node, "type").Decode(settings); err != nil {
+		return err
+	}
+	c.Settings = settings
+	return nil
+}
+
+// MarshalYAML encodes the settings inline next to the type
+func (c ConnectorConfig) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if c.Settings != nil {
+		if err := node.Encode(c.Settings); err != nil {
+			return nil, err
+		}
+	}
+
+	typeKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "type"}
+	typeValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: c.Type}
+	node.Content = append([]*yaml.Node{typeKey, typeValue}, node.Content...)
+	return node, nil
+}
+
+// validate records issues for a missing or unknown type and invalid settings
+func (c ConnectorConfig) validate(is *issues, path string) {
+	switch {
+	case c.Type == "":
+		is.add(path, "connector type is required")
+	case !slices.Contains(ConnectorTypes(), c.Type):
+		is.add(path+".type", "unknown connector type %q, must be one of: %v", c.Type, ConnectorTypes())
+	case c.Settings == nil:
+		is.add(path, "connector settings are required")
+	default:
+		if err := c.Settings.Validate(); err != nil {
+			is.add(path, "invalid %s settings: %v", c.Type, err)
+		}
+	}
+}
+
+// checkConnectorFields reports keys that are not settings of the connector type
+func checkConnectorFields(is *issues, node *yaml.Node, path string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	t := child(node, "type")
+	if t == nil {
+		return
+	}
+	settings, ok := NewConnectorSettings(t.Value)
+	if !ok {
+		return
+	}
+	checkKnownFields(is, withoutKey(node, "type"), reflect.TypeOf(settings), path)
+}
+
+// withoutKey returns a shallow copy of a mapping node without a key
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	c := *node
+	c.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			c.Content = append(c.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &c
+}
@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSOpener opens the filesystem addressed by the location part of a URI,
+// which is everything after scheme://
+type FSOpener func(location string) (fs.FS, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]FSOpener{
+		"file":   openLocal,
+		"bundle": openBundle,
+		"s3":     openS3,
+	}
+)
+
+// RegisterFS makes a config location scheme available to OpenFS. It panics if
+// the scheme is already registered.
+func RegisterFS(scheme string, open FSOpener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if _, dup := openers[scheme]; dup {
+		panic(fmt.Sprintf("parser: filesystem scheme %q registered twice", scheme))
+	}
+	openers[scheme] = open
+}
+
+// OpenFS opens the config filesystem addressed by a URI. Plain paths without a
+// scheme are opened from the local filesystem.
+func OpenFS(uri string) (fs.FS, error) {
+	scheme, location, ok := strings.Cut(uri, "://")
+	if !ok {
+		return openLocal(uri)
+	}
+
+	openersMu.RLock()
+	open, ok := openers[scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported config location scheme %q in %s", scheme, uri)
+	}
+
+	fsys, err := open(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config location %s: %w", uri, err)
+	}
+	return fsys, nil
+}
+
+// openLocal opens a directory of the local filesystem
+func openLocal(dir string) (fs.FS, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.DirFS(dir), nil
+}
+
+// openBundle opens a .zip, .tar, .tar.gz or .tgz archive of configs
+func openBundle(file string) (fs.FS, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name := strings.ToLower(file); {
+	case strings.HasSuffix(name, ".zip"):
+		return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readTar(gz)
+	case strings.HasSuffix(name, ".tar"):
+		return readTar(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported bundle format %s, must be one of: .zip, .tar, .tar.gz, .tgz", file)
+	}
+}
+
+// readTar loads the regular files of a tar archive into memory
+func readTar(r io.Reader) (fs.FS, error) {
+	files := make(memFS)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid file name in bundle: %s", header.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+}
+
+// memFS is a read-only in-memory filesystem of file contents keyed by path
+type memFS map[string][]byte
+
+// Open implements fs.FS
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if data, ok := m[name]; ok {
+		return &memFile{info: memInfo{name: path.Base(name), size: int64(len(data))}, r: bytes.NewReader(data)}, nil
+	}
+
+	// Directories are implied by the paths of the files below them
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	for file, data := range m {
+		rest, ok := strings.CutPrefix(file, prefix)
+		if !ok {
+			continue
+		}
+		entry, _, isDir := strings.Cut(rest, "/")
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		info := memInfo{name: entry, dir: isDir}
+		if !isDir {
+			info.size = int64(len(data))
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return &memFile{info: memInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+// memFile is an open file or directory of a memFS
+type memFile struct {
+	info    memInfo
+	r       *bytes.Reader
+	entries []fs.DirEntry
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: errors.New("is a directory")}
+	}
+	return f.r.Read(b)
+}
+
+// ReadDir implements fs.ReadDirFile
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// memInfo describes a file or directory of a memFS
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() any           { return nil }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
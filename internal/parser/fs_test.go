@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bundleFiles is a config tree shared by the bundle tests
+var bundleFiles = map[string]string{
+	"_defaults.yaml": `connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+`,
+	"nested/users.yaml": `id: users
+data_source:
+  domain: test
+  name: users
+  source:
+    connector: source
+  destination:
+    connector: source
+  fields:
+    - label: id
+      data_type: string
+`,
+}
+
+func TestOpenFS(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-fs-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Local directory
+	for name, content := range bundleFiles {
+		path := filepath.Join(tempDir, "dir", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// Tarball
+	var tarData bytes.Buffer
+	gz := gzip.NewWriter(&tarData)
+	tw := tar.NewWriter(gz)
+	for name, content := range bundleFiles {
+		tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(tempDir, "configs.tar.gz"), tarData.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+
+	// Zip
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	for name, content := range bundleFiles {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(tempDir, "configs.zip"), zipData.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		uri         string
+		expectError bool
+	}{
+		{name: "Plain Path", uri: filepath.Join(tempDir, "dir")},
+		{name: "File URI", uri: "file://" + filepath.Join(tempDir, "dir")},
+		{name: "Tarball Bundle", uri: "bundle://" + filepath.Join(tempDir, "configs.tar.gz")},
+		{name: "Zip Bundle", uri: "bundle://" + filepath.Join(tempDir, "configs.zip")},
+		{name: "Unknown Bundle Format", uri: "bundle://" + filepath.Join(tempDir, "dir"), expectError: true},
+		{name: "Unknown Scheme", uri: "ftp://configs", expectError: true},
+		{name: "Missing Directory", uri: "file:///non/existent/directory", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := ParseConfigRemoteDirectory(tt.uri)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfigRemoteDirectory() error = %v", err)
+			}
+
+			if len(*configs) != 1 {
+				t.Fatalf("Expected 1 config, got %d", len(*configs))
+			}
+			c := (*configs)[0]
+			if c.Connectors["source"].Settings == nil {
+				t.Errorf("Expected defaults to be merged, got %v", c.Connectors)
+			}
+			if !strings.HasPrefix(c.File(), tt.uri) || !strings.HasSuffix(c.File(), "nested/users.yaml") {
+				t.Errorf("File() = %s, want it reported under %s", c.File(), tt.uri)
+			}
+		})
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := memFS{
+		"a.yaml":       []byte("a"),
+		"dir/b.yaml":   []byte("bb"),
+		"dir/c/d.yaml": []byte("ddd"),
+	}
+
+	var files []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir() error = %v", err)
+	}
+	if strings.Join(files, ",") != "a.yaml,dir/b.yaml,dir/c/d.yaml" {
+		t.Errorf("WalkDir() visited %v", files)
+	}
+
+	data, err := fs.ReadFile(fsys, "dir/c/d.yaml")
+	if err != nil || string(data) != "ddd" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+
+	if _, err := fs.Stat(fsys, "missing.yaml"); err == nil {
+		t.Error("Stat() of missing file should return error")
+	}
+}
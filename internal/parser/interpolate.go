@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces interpolated values when a config is logged or printed
+const redacted = "[REDACTED]"
+
+// interpolated lists the top-level keys whose values support substitution
+var interpolated = []string{"connectors", "data_source"}
+
+// reference matches $$ escapes and ${...} references
+var reference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// interpolate substitutes ${ENV_VAR}, ${ENV_VAR:-default} and ${file:/path}
+// references in the scalar values of the interpolated sections. The paths of
+// substituted values are recorded so they can be redacted.
+func interpolate(is *issues, doc *document) {
+	for _, key := range interpolated {
+		if node := child(doc.root, key); node != nil {
+			interpolateNode(is, doc, node, key)
+		}
+	}
+}
+
+// interpolateNode walks a node, resolving references in every scalar
+func interpolateNode(is *issues, doc *document, node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			interpolateNode(is, doc, node.Content[i+1], path+"."+node.Content[i].Value)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			interpolateNode(is, doc, item, path+"."+strconv.Itoa(i))
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return
+		}
+
+		substituted := false
+		value := reference.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			substituted = true
+
+			resolved, err := resolve(match[2 : len(match)-1])
+			if err != nil {
+				is.add(path, "%v", err)
+			}
+			return resolved
+		})
+		if value == node.Value {
+			return
+		}
+
+		node.Value = value
+		if node.Style == 0 {
+			// Let plain scalars resolve to their natural type again
+			node.Tag = ""
+		}
+		if substituted {
+			doc.secrets = append(doc.secrets, path)
+		}
+	}
+}
+
+// resolve returns the value of a single reference
+func resolve(ref string) (string, error) {
+	if file, ok := strings.CutPrefix(ref, "file:"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", file, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty variable reference ${%s}", ref)
+	}
+	// Like the shell, a default also replaces an empty variable, while a
+	// variable without one only has to be set
+	value, set := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return fallback, nil
+	}
+	if !set {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// Redacted returns the config as a YAML node with interpolated values masked
+func (c Config) Redacted() (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(c); err != nil {
+		return nil, err
+	}
+
+	if c.doc != nil {
+		for _, path := range c.doc.secrets {
+			if n := lookup(node, path); n != nil && n.Kind == yaml.ScalarNode {
+				n.SetString(redacted)
+			}
+		}
+	}
+
+	return node, nil
+}
+
+// String renders the config as YAML with interpolated values masked
+func (c Config) String() string {
+	node, err := c.Redacted()
+	if err != nil {
+		return fmt.Sprintf("config %s: %v", c.Id, err)
+	}
+
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Sprintf("config %s: %v", c.Id, err)
+	}
+	return string(data)
+}
+
+// LogValue implements slog.LogValuer so resolved secrets never reach the logs
+func (c Config) LogValue() slog.Value {
+	node, err := c.Redacted()
+	if err != nil {
+		return slog.GroupValue(slog.String("id", c.Id), slog.String("error", err.Error()))
+	}
+
+	var value map[string]any
+	if err := node.Decode(&value); err != nil {
+		return slog.GroupValue(slog.String("id", c.Id), slog.String("error", err.Error()))
+	}
+	return slog.AnyValue(value)
+}
+
+// Fingerprint returns a digest of the resolved config, used to detect changes
+// between reloads
+func (c Config) Fingerprint() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
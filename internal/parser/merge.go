@@ -0,0 +1,484 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultsFiles are the names of the per-directory defaults files
+var defaultsFiles = []string{"_defaults.yaml", "_defaults.yml"}
+
+// layer is a single YAML document contributing to a config
+type layer struct {
+	file string
+	root *yaml.Node
+	// profile is set to the selected profile for its profiles block
+	profile string
+}
+
+// loader reads config documents and resolves their inheritance. Values are
+// merged with the following precedence, lowest first:
+//
+//  1. _defaults.yaml of the config root directory
+//  2. _defaults.yaml of each nested directory down to the config file
+//  3. the file named by extends, itself resolved the same way as 3-4
+//  4. the config file
+//  5. the profiles block of the selected profile of each of 1-4, in the
+//     same order
+//
+// Profile overrides come after every other layer so that, for example, a
+// profile in the root _defaults.yaml can disable the schedules set by nested
+// defaults files.
+type loader struct {
+	fsys fs.FS
+	// name is the location of fsys that file names are reported under
+	name string
+	// defaults controls whether _defaults.yaml files are merged
+	defaults bool
+	// profile is the profile whose overrides are applied
+	profile string
+	// profiles records every profile name declared by the files read
+	profiles map[string]bool
+	files    map[string][]*yaml.Node
+}
+
+// newLoader creates a loader for the configs of a filesystem reported under
+// the given name
+func newLoader(fsys fs.FS, name string, defaults bool, profile string) *loader {
+	return &loader{
+		fsys:     fsys,
+		name:     name,
+		defaults: defaults,
+		profile:  profile,
+		profiles: make(map[string]bool),
+		files:    make(map[string][]*yaml.Node),
+	}
+}
+
+// display returns the name a file of the filesystem is reported under
+func (l *loader) display(file string) string {
+	if l.name == "" || l.name == "." {
+		return file
+	}
+	return strings.TrimSuffix(l.name, "/") + "/" + file
+}
+
+// configs returns the layers of every config declared in a file. A file may
+// hold several --- separated documents, and each entry of a data_sources list
+// becomes its own config.
+func (l *loader) configs(file string) ([]source, []Issue) {
+	defaults, problems := l.directoryDefaults(file)
+
+	docs, readProblems := l.read(file)
+	problems = append(problems, readProblems...)
+
+	var sources []source
+	for _, doc := range docs {
+		expanded, expandProblems := expand(l.display(file), doc)
+		problems = append(problems, expandProblems...)
+		for _, root := range expanded {
+			chain, chainProblems := l.chain(file, root, nil)
+			problems = append(problems, chainProblems...)
+
+			// Profile overrides apply on top of every other layer
+			var layers, overrides []layer
+			for _, layer := range append(slices.Clone(defaults), chain...) {
+				if layer.profile != "" {
+					overrides = append(overrides, layer)
+				} else {
+					layers = append(layers, layer)
+				}
+			}
+			sources = append(sources, source{layers: append(layers, overrides...), expanded: root != doc})
+		}
+	}
+	return sources, problems
+}
+
+// source is the set of layers that make up a single config
+type source struct {
+	layers []layer
+	// expanded is set when the config is an entry of a data_sources list
+	expanded bool
+}
+
+// read parses every document of a YAML file into mapping nodes, caching the
+// result
+func (l *loader) read(file string) ([]*yaml.Node, []Issue) {
+	if docs, ok := l.files[file]; ok {
+		return docs, nil
+	}
+
+	name := l.display(file)
+	data, err := fs.ReadFile(l.fsys, file)
+	if err != nil {
+		return nil, []Issue{{File: name, Message: fmt.Sprintf("failed to read config file: %v", err)}}
+	}
+
+	is := &issues{doc: &document{file: name}}
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		root := &yaml.Node{}
+		err := decoder.Decode(root)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			decodeIssues(is, name, err)
+			return nil, is.list
+		}
+		if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+			root = root.Content[0]
+		}
+		if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+			// Skip empty documents such as a trailing ---
+			continue
+		}
+		if root.Kind != yaml.MappingNode {
+			is.list = append(is.list, Issue{File: name, Line: root.Line, Column: root.Column, Message: "config must be a mapping"})
+			continue
+		}
+		if _, err := migrate(root); err != nil {
+			decodeIssues(is, name, err)
+			continue
+		}
+		l.checkProfiles(is, root)
+		docs = append(docs, root)
+	}
+
+	l.files[file] = docs
+	return docs, is.list
+}
+
+// partial reads a file that is merged into other configs, which must hold a
+// single document
+func (l *loader) partial(file string) (*yaml.Node, []Issue) {
+	docs, problems := l.read(file)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	if len(docs) != 1 {
+		return nil, []Issue{{File: l.display(file), Message: fmt.Sprintf("shared config must contain exactly one document, found %d", len(docs))}}
+	}
+	return docs[0], nil
+}
+
+// chain returns the layers of a document and the files it extends, base first
+func (l *loader) chain(file string, root *yaml.Node, seen []string) ([]layer, []Issue) {
+	var layers []layer
+	var problems []Issue
+	if extends := child(root, "extends"); extends != nil && extends.Value != "" {
+		target := path.Join(path.Dir(file), extends.Value)
+		at := Issue{File: l.display(file), Line: extends.Line, Column: extends.Column, Path: "extends"}
+
+		switch {
+		case slices.Contains(seen, target) || target == file:
+			var names []string
+			for _, name := range append(seen, file, target) {
+				names = append(names, l.display(name))
+			}
+			at.Message = fmt.Sprintf("extends cycle: %s", strings.Join(names, " -> "))
+			problems = append(problems, at)
+		default:
+			if _, err := fs.Stat(l.fsys, target); err != nil {
+				at.Message = fmt.Sprintf("extended config %s does not exist", l.display(target))
+				problems = append(problems, at)
+				break
+			}
+			base, baseProblems := l.partial(target)
+			problems = append(problems, baseProblems...)
+			if base != nil {
+				chain, chainProblems := l.chain(target, base, append(seen, file))
+				layers = append(layers, chain...)
+				problems = append(problems, chainProblems...)
+			}
+		}
+	}
+
+	return append(layers, l.layers(file, root)...), problems
+}
+
+// layers splits a document into its base layer and the override layer of the
+// selected profile
+func (l *loader) layers(file string, root *yaml.Node) []layer {
+	layers := []layer{{file: l.display(file), root: withoutKey(root, "profiles")}}
+	if l.profile == "" {
+		return layers
+	}
+	if profiles := child(root, "profiles"); profiles != nil {
+		if block := child(profiles, l.profile); block != nil && block.Kind == yaml.MappingNode {
+			layers = append(layers, layer{file: l.display(file), root: block, profile: l.profile})
+		}
+	}
+	return layers
+}
+
+// checkProfiles checks that a profiles block maps profile names to config
+// overrides and records the profile names
+func (l *loader) checkProfiles(is *issues, root *yaml.Node) {
+	profiles := child(root, "profiles")
+	if profiles == nil {
+		return
+	}
+
+	is.doc.root = root
+	if profiles.Kind != yaml.MappingNode {
+		is.add("profiles", "profiles must map profile names to config overrides")
+		return
+	}
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		name, block := profiles.Content[i].Value, profiles.Content[i+1]
+		path := "profiles." + name
+		l.profiles[name] = true
+		if block.Kind != yaml.MappingNode {
+			is.add(path, "profile %q must be a mapping of config overrides", name)
+			continue
+		}
+		if child(block, "extends") != nil {
+			is.add(path+".extends", "extends cannot be overridden by a profile")
+		}
+		checkKnownFields(is, block, reflect.TypeFor[Config](), path)
+	}
+}
+
+// checkProfile reports when a profile was selected that no file declares
+func (l *loader) checkProfile() error {
+	if l.profile == "" || l.profiles[l.profile] {
+		return nil
+	}
+	return fmt.Errorf("profile %q is not declared by any config, declared profiles: %v", l.profile, sortedKeys(l.profiles))
+}
+
+// expand splits a document with a data_sources list into one document per
+// entry, each sharing the rest of the document
+func expand(file string, root *yaml.Node) ([]*yaml.Node, []Issue) {
+	var key, list *yaml.Node
+	shared := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "data_sources" {
+			key, list = root.Content[i], root.Content[i+1]
+			continue
+		}
+		shared.Content = append(shared.Content, root.Content[i], root.Content[i+1])
+	}
+	if list == nil {
+		return []*yaml.Node{root}, nil
+	}
+
+	at := Issue{File: file, Line: key.Line, Column: key.Column, Path: "data_sources"}
+	switch {
+	case child(root, "data_source") != nil:
+		at.Message = "data_source and data_sources cannot be used together"
+		return nil, []Issue{at}
+	case list.Kind != yaml.SequenceNode || len(list.Content) == 0:
+		at.Message = "data_sources must be a non-empty list"
+		return nil, []Issue{at}
+	}
+
+	docs := make([]*yaml.Node, 0, len(list.Content))
+	for _, entry := range list.Content {
+		doc := *shared
+		doc.Content = append(slices.Clone(shared.Content),
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "data_source", Line: entry.Line, Column: entry.Column},
+			entry,
+		)
+		docs = append(docs, &doc)
+	}
+	return docs, nil
+}
+
+// directoryDefaults returns the layers of every defaults file between the
+// filesystem root and the directory of a config file, outermost first
+func (l *loader) directoryDefaults(file string) ([]layer, []Issue) {
+	if !l.defaults {
+		return nil, nil
+	}
+
+	dirs := []string{"."}
+	if dir := path.Dir(file); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, path.Join(parts[:i+1]...))
+		}
+	}
+
+	var layers []layer
+	var problems []Issue
+	for _, dir := range dirs {
+		for _, name := range defaultsFiles {
+			file := path.Join(dir, name)
+			if _, err := fs.Stat(l.fsys, file); err != nil {
+				continue
+			}
+			root, rootProblems := l.partial(file)
+			problems = append(problems, rootProblems...)
+			if root == nil {
+				continue
+			}
+			chain, chainProblems := l.chain(file, root, nil)
+			layers = append(layers, chain...)
+			problems = append(problems, chainProblems...)
+		}
+	}
+	return layers, problems
+}
+
+// isPartial reports whether a file only contributes to other configs, such as
+// _defaults.yaml or a shared base named by extends
+func isPartial(name string) bool {
+	return strings.HasPrefix(path.Base(name), "_")
+}
+
+// merge deep merges layers into a single document. Mappings are merged key by
+// key, while sequences and scalars of later layers replace earlier values.
+func merge(layers []layer) *document {
+	doc := &document{files: make(map[*yaml.Node]string), profiles: make(map[*yaml.Node]string)}
+	var root *yaml.Node
+	for _, l := range layers {
+		root = mergeNode(doc, root, l.root, l)
+		doc.file = l.file
+	}
+	doc.root = root
+	return doc
+}
+
+// mergeNode merges src into dst, returning the merged node
+func mergeNode(doc *document, dst, src *yaml.Node, l layer) *yaml.Node {
+	if dst == nil || dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return copyNode(doc, src, l)
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				dst.Content[j+1] = mergeNode(doc, dst.Content[j+1], value, l)
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst.Content = append(dst.Content, copyNode(doc, key, l), copyNode(doc, value, l))
+		}
+	}
+	return dst
+}
+
+// copyNode deep copies a node, recording the file and profile every copy
+// came from
+func copyNode(doc *document, node *yaml.Node, l layer) *yaml.Node {
+	c := *node
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, n := range node.Content {
+		c.Content[i] = copyNode(doc, n, l)
+	}
+	doc.files[&c] = l.file
+	if l.profile != "" {
+		doc.profiles[&c] = l.profile
+	}
+	return &c
+}
+
+// Sources of a config value, see Origin
+const (
+	ORIGIN_DEFAULTS = "defaults"
+	ORIGIN_PROFILE  = "profile"
+	ORIGIN_FILE     = "file"
+	ORIGIN_ENV      = "env"
+)
+
+// Origin is where a value of a config came from
+type Origin struct {
+	// Source is defaults for a _defaults.yaml file, profile for a profile
+	// override, env for an interpolated value and file otherwise
+	Source string
+	// Profile is the profile of a profile override
+	Profile string
+	File    string
+	Line    int
+}
+
+// String describes an origin, such as "profile prod configs/example.yaml:50"
+func (o Origin) String() string {
+	if o.Profile != "" {
+		return fmt.Sprintf("%s %s %s:%d", o.Source, o.Profile, o.File, o.Line)
+	}
+	return fmt.Sprintf("%s %s:%d", o.Source, o.File, o.Line)
+}
+
+// Origin returns where a value of the config was declared, given its dotted
+// path such as data_source.trigger.cron. It reports false for values that
+// are not declared in any file, such as defaults of connector settings.
+func (c *Config) Origin(at string) (Origin, bool) {
+	if c.doc == nil {
+		return Origin{}, false
+	}
+	node := lookup(c.doc.root, at)
+	if node == nil {
+		return Origin{}, false
+	}
+
+	o := Origin{Source: ORIGIN_FILE, File: c.doc.fileOf(node), Line: node.Line}
+	switch {
+	case slices.Contains(c.doc.secrets, at):
+		o.Source = ORIGIN_ENV
+	case c.doc.profiles[node] != "":
+		o.Source, o.Profile = ORIGIN_PROFILE, c.doc.profiles[node]
+	case slices.Contains(defaultsFiles, path.Base(o.File)):
+		o.Source = ORIGIN_DEFAULTS
+	}
+	return o, true
+}
+
+// Annotated returns the config as a YAML node like Redacted, with the origin
+// of every value in a "from:" line comment
+func (c Config) Annotated() (*yaml.Node, error) {
+	node, err := c.Redacted()
+	if err != nil {
+		return nil, err
+	}
+	c.annotate(node, "")
+	return node, nil
+}
+
+// annotate adds the origin of every scalar under node, at the given path, to
+// its line comment
+func (c *Config) annotate(node *yaml.Node, at string) {
+	join := func(key string) string {
+		if at == "" {
+			return key
+		}
+		return at + "." + key
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			c.annotate(n, at)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			c.annotate(node.Content[i+1], join(node.Content[i].Value))
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			c.annotate(n, join(strconv.Itoa(i)))
+		}
+	case yaml.ScalarNode:
+		if o, ok := c.Origin(at); ok {
+			node.LineComment = "from: " + o.String()
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Migration upgrades a config document from one schema version to the next
+type Migration struct {
+	// From is the schema version the migration upgrades from
+	From        int
+	Description string
+	// Apply rewrites the document mapping node in place
+	Apply func(doc *yaml.Node) error
+}
+
+// migrations upgrade documents one version at a time, in order. Documents
+// without a schema_version are version 1.
+var migrations = []Migration{
+	{From: 1, Description: "replace trigger.event with trigger.depends_on", Apply: migrateEventToDependsOn},
+}
+
+// CurrentSchemaVersion returns the schema version configs are migrated to
+func CurrentSchemaVersion() int {
+	return len(migrations) + 1
+}
+
+// migrate upgrades a document mapping node in memory to the current schema
+// version, returning whether it changed
+func migrate(doc *yaml.Node) (bool, error) {
+	version := 1
+	node := child(doc, "schema_version")
+	if node != nil {
+		v, err := strconv.Atoi(node.Value)
+		if err != nil || v < 1 {
+			return false, fmt.Errorf("line %d: invalid schema_version %q", node.Line, node.Value)
+		}
+		version = v
+	}
+
+	current := CurrentSchemaVersion()
+	if version > current {
+		return false, fmt.Errorf("line %d: schema_version %d is newer than the supported version %d", node.Line, version, current)
+	}
+
+	for _, m := range migrations[version-1:] {
+		if err := m.Apply(doc); err != nil {
+			return false, fmt.Errorf("failed to migrate from schema_version %d (%s): %w", m.From, m.Description, err)
+		}
+	}
+
+	if node == nil {
+		// Stamp the version after the id so it sits below any leading comment
+		at := 0
+		if len(doc.Content) >= 2 && doc.Content[0].Value == "id" {
+			at = 2
+		}
+		stamp := []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "schema_version"},
+			{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(current)},
+		}
+		doc.Content = append(doc.Content[:at], append(stamp, doc.Content[at:]...)...)
+		return true, nil
+	}
+
+	if version == current {
+		return false, nil
+	}
+	node.SetString(strconv.Itoa(current))
+	node.Tag = "!!int"
+	return true, nil
+}
+
+// migrateEventToDependsOn turns the event trigger, which was never wired up,
+// into a dependency on the config the event named
+func migrateEventToDependsOn(doc *yaml.Node) error {
+	for _, dataSource := range dataSourceNodes(doc) {
+		trigger := child(dataSource, "trigger")
+		if trigger == nil || trigger.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i < len(trigger.Content); i += 2 {
+			key, value := trigger.Content[i], trigger.Content[i+1]
+			if key.Value != "event" {
+				continue
+			}
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: event must be a config id", value.Line)
+			}
+			key.Value = "depends_on"
+			trigger.Content[i+1] = &yaml.Node{
+				Kind:        yaml.SequenceNode,
+				Tag:         "!!seq",
+				Style:       yaml.FlowStyle,
+				Content:     []*yaml.Node{value},
+				LineComment: value.LineComment,
+			}
+			value.LineComment = ""
+		}
+	}
+	return nil
+}
+
+// dataSourceNodes returns the data source mappings of a document, including
+// data_sources entries and those of profiles blocks
+func dataSourceNodes(doc *yaml.Node) []*yaml.Node {
+	docs := []*yaml.Node{doc}
+	if profiles := child(doc, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 1; i < len(profiles.Content); i += 2 {
+			docs = append(docs, profiles.Content[i])
+		}
+	}
+
+	var dataSources []*yaml.Node
+	for _, doc := range docs {
+		if dataSource := child(doc, "data_source"); dataSource != nil {
+			dataSources = append(dataSources, dataSource)
+		}
+		if list := child(doc, "data_sources"); list != nil && list.Kind == yaml.SequenceNode {
+			dataSources = append(dataSources, list.Content...)
+		}
+	}
+	return dataSources
+}
+
+// MigrateFile upgrades every document of a config file to the current schema
+// version, keeping comments. It returns the original and migrated contents,
+// which are equal when the file is up to date.
+func MigrateFile(filePath string) ([]byte, []byte, error) {
+	before, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var docs []*yaml.Node
+	changed := false
+	decoder := yaml.NewDecoder(bytes.NewReader(before))
+	for {
+		doc := &yaml.Node{}
+		err := decoder.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+		}
+
+		root := doc
+		if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 {
+			root = doc.Content[0]
+		}
+		if root.Kind == yaml.MappingNode {
+			docChanged, err := migrate(root)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+			}
+			changed = changed || docChanged
+		}
+		docs = append(docs, doc)
+	}
+
+	if !changed {
+		return before, before, nil
+	}
+
+	var after bytes.Buffer
+	encoder := yaml.NewEncoder(&after)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode %s: %w", filePath, err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode %s: %w", filePath, err)
+	}
+
+	return before, restoreLayout(before, after.Bytes()), nil
+}
+
+// restoreLayout puts back the blank lines and spacing of the original file
+// that the yaml encoder drops, for every line a migration did not change
+func restoreLayout(before, after []byte) []byte {
+	var original []string
+	blanks := map[int]int{}
+	for _, line := range lines(before) {
+		if strings.TrimSpace(line) == "" {
+			blanks[len(original)]++
+			continue
+		}
+		original = append(original, line)
+	}
+
+	// Lines match when they only differ in whitespace
+	same := func(a, b string) bool {
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}
+
+	var out strings.Builder
+	i, trailing := 0, 0
+	blank := func(n int) {
+		// The encoder keeps some blank lines itself, so only top them up
+		out.WriteString(strings.Repeat("\n", max(n-trailing, 0)))
+	}
+	for _, e := range editScript(original, lines(after), same) {
+		switch e.op {
+		case ' ':
+			blank(blanks[i])
+			out.WriteString(original[i])
+			i++
+			trailing = 0
+		case '-':
+			i++
+		case '+':
+			out.WriteString(e.line)
+			if strings.TrimSpace(e.line) == "" {
+				trailing++
+			} else {
+				trailing = 0
+			}
+		}
+	}
+	blank(blanks[len(original)])
+	return []byte(out.String())
+}
+
+// Diff returns a unified diff between two versions of a file
+func Diff(name string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+
+	x := lines(a)
+	y := lines(b)
+
+	edits := editScript(x, y, func(a, b string) bool { return a == b })
+
+	// Group edits into hunks with three lines of context
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", name, name)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		lo := max(start-context, 0)
+		hi := start
+		for k := start; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				hi = k
+			} else if k-hi > 2*context {
+				break
+			}
+		}
+		hi = min(hi+context+1, len(edits))
+
+		aStart, bStart := 1, 1
+		for _, e := range edits[:lo] {
+			if e.op != '+' {
+				aStart++
+			}
+			if e.op != '-' {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, e := range edits[lo:hi] {
+			if e.op != '+' {
+				aLen++
+			}
+			if e.op != '-' {
+				bLen++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, e := range edits[lo:hi] {
+			line := strings.TrimSuffix(e.line, "\n")
+			fmt.Fprintf(&out, "%c%s\n", e.op, line)
+		}
+		start = hi
+	}
+	return out.String()
+}
+
+// edit is a single line of an edit script
+type edit struct {
+	op   byte
+	line string
+}
+
+// editScript returns the line edits turning x into y, based on their longest
+// common subsequence
+func editScript(x, y []string, equal func(a, b string) bool) []edit {
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if equal(x[i], y[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && equal(x[i], y[j]):
+			edits = append(edits, edit{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+// lines splits text into lines, keeping their line endings
+func lines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	split := strings.SplitAfter(string(text), "\n")
+	if split[len(split)-1] == "" {
+		split = split[:len(split)-1]
+	}
+	return split
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrate(t *testing.T) {
+	// Register a migration that renames a key for the duration of the test
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = append(migrations, Migration{
+		From:        CurrentSchemaVersion(),
+		Description: "rename owner to domain",
+		Apply: func(doc *yaml.Node) error {
+			if dataSource := child(doc, "data_source"); dataSource != nil {
+				for i := 0; i < len(dataSource.Content); i += 2 {
+					if dataSource.Content[i].Value == "owner" {
+						dataSource.Content[i].Value = "domain"
+					}
+				}
+			}
+			return nil
+		},
+	})
+	current := CurrentSchemaVersion()
+
+	tempDir, err := os.MkdirTemp("", "config-migrate-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	old := `# Customers config
+
+id: customers
+
+# Connectors
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily  # Partition by day
+data_source:
+  owner: sales
+  name: customers
+  source:
+    connector: source
+  destination:
+    connector: source
+  fields:
+    - label: id
+      data_type: string
+`
+	file := filepath.Join(tempDir, "customers.yaml")
+	if err := os.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// Old documents are upgraded in memory at parse time
+	configs, err := ParseConfigDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("ParseConfigDirectory() error = %v", err)
+	}
+	config := (*configs)[0]
+	if config.DataSource.Domain != "sales" || config.SchemaVersion != current {
+		t.Errorf("Migrated config = domain %q version %d, want sales version %d", config.DataSource.Domain, config.SchemaVersion, current)
+	}
+
+	// Migrating the file keeps comments and layout of unchanged lines
+	before, after, err := MigrateFile(file)
+	if err != nil {
+		t.Fatalf("MigrateFile() error = %v", err)
+	}
+	if string(before) != old {
+		t.Errorf("MigrateFile() before = %q, want the original file", before)
+	}
+	expected := strings.NewReplacer(
+		"id: customers\n", fmt.Sprintf("id: customers\nschema_version: %d\n", current),
+		"owner: sales", "domain: sales",
+	).Replace(old)
+	if string(after) != expected {
+		t.Errorf("MigrateFile() after =\n%s\nwant\n%s", after, expected)
+	}
+
+	diff := Diff("customers.yaml", before, after)
+	for _, line := range []string{fmt.Sprintf("+schema_version: %d", current), "-  owner: sales", "+  domain: sales", " id: customers"} {
+		if !strings.Contains(diff, line+"\n") {
+			t.Errorf("Diff() missing %q in\n%s", line, diff)
+		}
+	}
+
+	// Up to date files are left alone
+	os.WriteFile(file, after, 0644)
+	before, after, err = MigrateFile(file)
+	if err != nil {
+		t.Fatalf("MigrateFile() error = %v", err)
+	}
+	if Diff("customers.yaml", before, after) != "" {
+		t.Errorf("MigrateFile() changed an up to date file")
+	}
+
+	// Versions newer than the binary are rejected
+	newer := strings.Replace(old, "id: customers", "id: customers\nschema_version: 99", 1)
+	os.WriteFile(file, []byte(newer), 0644)
+	if _, err := ParseConfigDirectory(tempDir); err == nil || !strings.Contains(err.Error(), "schema_version 99 is newer") {
+		t.Errorf("Expected newer schema_version error, got %v", err)
+	}
+	if _, _, err := MigrateFile(file); err == nil {
+		t.Error("MigrateFile() with a newer schema_version should return error")
+	}
+}
+
+func TestMigrateEventToDependsOn(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-migrate-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	old := `id: orders
+schema_version: 1
+data_source:
+  trigger:
+    event: customers # Wait for customers
+`
+	file := filepath.Join(tempDir, "orders.yaml")
+	if err := os.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, after, err := MigrateFile(file)
+	if err != nil {
+		t.Fatalf("MigrateFile() error = %v", err)
+	}
+	expected := `id: orders
+schema_version: 2
+data_source:
+  trigger:
+    depends_on: [customers] # Wait for customers
+`
+	if string(after) != expected {
+		t.Errorf("MigrateFile() after =\n%s\nwant\n%s", after, expected)
+	}
+}
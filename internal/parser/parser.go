@@ -1,26 +1,49 @@
 package parser
 
 import (
+	"cmp"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"slices"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 // Config represents the global configuration
 type (
 	Configs []Config
 	Config  struct {
-		Id         string         `yaml:"id"`
-		Connectors map[string]any `yaml:"connectors"`
-		DataSource DataSource     `yaml:"data_source"`
+		Id            string                     `yaml:"id"`
+		SchemaVersion int                        `yaml:"schema_version"`
+		Extends       string                     `yaml:"extends,omitempty"`
+		Connectors    map[string]ConnectorConfig `yaml:"connectors"`
+		DataSource    DataSource                 `yaml:"data_source"`
+
+		doc *document
 	}
 )
 
+// Lookup returns the config with the given id
+func (cs Configs) Lookup(id string) (Config, bool) {
+	for _, c := range cs {
+		if c.Id == id {
+			return c, true
+		}
+	}
+	return Config{}, false
+}
+
+// File returns the path of the file the config was parsed from
+func (c *Config) File() string {
+	if c.doc == nil {
+		return ""
+	}
+	return c.doc.file
+}
+
 // DataSource represents a data source configuration
 type DataSource struct {
 	Domain      string            `yaml:"domain"`
@@ -29,7 +52,10 @@ type DataSource struct {
 	Destination DestinationConfig `yaml:"destination"`
 	Trigger     TriggerConfig     `yaml:"trigger"`
 	Validate    ValidationConfig  `yaml:"validate"`
-	Fields      []FieldConfig     `yaml:"fields"`
+	Retry       RetryConfig       `yaml:"retry,omitempty"`
+	// Timeout cancels a job that runs longer, 0 never times out
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Fields  []FieldConfig `yaml:"fields"`
 }
 
 // SourceConfig represents the source configuration
@@ -49,9 +75,23 @@ type DestinationConfig struct {
 
 // TriggerConfig represents the schedule configuration
 type TriggerConfig struct {
-	Cron         string `yaml:"cron,omitempty"`
-	EventId      string `yaml:"event,omitempty"`
-	RandomOffset bool   `yaml:"random_offset"`
+	Cron         string   `yaml:"cron,omitempty"`
+	DependsOn    []string `yaml:"depends_on,omitempty"`
+	RandomOffset bool     `yaml:"random_offset"`
+}
+
+// RetryConfig represents the retry policy of failed jobs
+type RetryConfig struct {
+	// MaxAttempts counts the first run, 0 or 1 never retries
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Backoff is fixed, exponential or jitter, defaults to exponential
+	Backoff string `yaml:"backoff,omitempty"`
+	// Delay is the wait before the first retry, defaults to 30s
+	Delay time.Duration `yaml:"delay,omitempty"`
+	// MaxDelay caps the wait of exponential and jitter backoff, defaults to 10m
+	MaxDelay time.Duration `yaml:"max_delay,omitempty"`
+	// RetryOn lists the retried error classes, defaults to connector
+	RetryOn []string `yaml:"retry_on,omitempty"`
 }
 
 // ValidationConfig represents the validation configuration
@@ -66,31 +106,113 @@ type FieldConfig struct {
 	DataType string `yaml:"data_type"`
 }
 
-// ParseConfigFile parses a YAML config file into a Config struct
-func ParseConfigFile(filePath string) (*Config, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
+// ParseConfigFile parses and validates a YAML config file into a Config
+// struct. The files it extends are merged in, directory defaults are only
+// applied by ParseConfigDirectory. Files declaring several configs must be
+// loaded with ParseConfigDirectory.
+func ParseConfigFile(filePath string, opts ...Option) (*Config, error) {
+	if _, err := os.Stat(filePath); err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	config := &Config{}
-	err = yaml.Unmarshal(data, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
+	o := newOptions(opts)
+	dir, file := filepath.Split(filePath)
+	loader := newLoader(os.DirFS(cmp.Or(dir, ".")), dir, false, o.profile)
+	sources, problems := loader.configs(file)
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("config file %s declares %d configs, expected 1", filePath, len(sources))
+	}
+	if len(sources) == 0 {
+		return nil, collect(problems, []Issue{{File: filePath, Message: "config file is empty"}})
+	}
+
+	config, is := parseConfig(sources[0])
+	config.validate(is)
+	if err := collect(problems, is.list); err != nil {
+		return nil, err
+	}
+	if err := loader.checkProfile(); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
 
-// ParseConfigDirectory parses all YAML files in a directory into a Config struct
-func ParseConfigDirectory(dirPath string) (*Configs, error) {
+// parseConfig merges the layers of a source into a single document and
+// decodes it into a Config, recording unknown keys and decode errors as
+// issues
+func parseConfig(src source) (*Config, *issues) {
+	doc := merge(src.layers)
+	is := &issues{doc: doc}
+
+	// Decode each layer on its own so type errors point at the right file
+	decodeFailed := false
+	for _, l := range src.layers {
+		if err := l.root.Decode(&Config{}); err != nil {
+			decodeIssues(is, l.file, err)
+			decodeFailed = true
+		}
+	}
+
+	interpolate(is, doc)
+	checkKnownFields(is, doc.root, reflect.TypeFor[Config](), "")
+
+	config := &Config{}
+	if err := doc.root.Decode(config); err != nil && !decodeFailed {
+		decodeIssues(is, doc.file, err)
+	}
+	config.doc = doc
+
+	// Entries of a data_sources list derive their id from the shared id, or
+	// from the domain when there is none
+	if src.expanded {
+		prefix := cmp.Or(config.Id, config.DataSource.Domain)
+		config.Id = fmt.Sprintf("%s.%s", prefix, config.DataSource.Name)
+	}
+
+	return config, is
+}
+
+// collect combines issues into a single sorted ValidationError without
+// duplicates, or returns nil when there are none
+func collect(lists ...[]Issue) error {
+	var all []Issue
+	for _, list := range lists {
+		for _, issue := range list {
+			if !slices.Contains(all, issue) {
+				all = append(all, issue)
+			}
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(all, func(a, b Issue) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return &ValidationError{Issues: all}
+}
+
+// ParseConfigDirectory parses all YAML files in a directory into a Config
+// struct. Files whose name starts with an underscore are partials that are
+// only merged into other configs, see loader for the precedence rules. Every
+// file is validated and all issues are reported together.
+func ParseConfigDirectory(dirPath string, opts ...Option) (*Configs, error) {
+	return ParseConfigFS(os.DirFS(dirPath), dirPath, opts...)
+}
+
+// ParseConfigFS parses all YAML files of a filesystem into a Config struct
+// like ParseConfigDirectory, reporting file names under the given name
+func ParseConfigFS(fsys fs.FS, name string, opts ...Option) (*Configs, error) {
 	var configs Configs
+	var problems []Issue
+	loader := newLoader(fsys, name, true, newOptions(opts).profile)
 
 	// Track the number of files processed
 	filesProcessed := 0
 
-	var seen []string
-	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -103,22 +225,21 @@ func ParseConfigDirectory(dirPath string) (*Configs, error) {
 		if ext != ".yaml" && ext != ".yml" {
 			return nil
 		}
-
-		slog.Info("Processing config file", "file", path)
-
-		// Parse the individual config file
-		config, parseErr := ParseConfigFile(path)
-		if parseErr != nil {
-			return fmt.Errorf("failed to parse config file %s: %w", path, parseErr)
+		if isPartial(path) {
+			return nil
 		}
+		filesProcessed++
 
-		if slices.Contains(seen, config.Id) {
-			return fmt.Errorf("failed to parse config file %s: config with same id already exists", path)
-		}
-		seen = append(seen, config.Id)
+		slog.Debug("Processing config file", "file", loader.display(path))
 
-		configs = append(configs, *config)
-		filesProcessed++
+		// Parse every config of the file on top of its directory defaults
+		sources, sourceProblems := loader.configs(path)
+		problems = append(problems, sourceProblems...)
+		for _, src := range sources {
+			config, is := parseConfig(src)
+			problems = append(problems, is.list...)
+			configs = append(configs, *config)
+		}
 		return nil
 	})
 	if err != nil {
@@ -126,10 +247,21 @@ func ParseConfigDirectory(dirPath string) (*Configs, error) {
 	}
 
 	if filesProcessed == 0 {
-		return nil, fmt.Errorf("no YAML config files found in directory: %s", dirPath)
+		return nil, fmt.Errorf("no YAML config files found in directory: %s", name)
 	}
 
-	slog.Info(
+	// Validate every config together so duplicate ids are detected
+	if err := configs.Validate(); err != nil {
+		problems = append(problems, err.(*ValidationError).Issues...)
+	}
+	if err := collect(problems); err != nil {
+		return nil, err
+	}
+	if err := loader.checkProfile(); err != nil {
+		return nil, err
+	}
+
+	slog.Debug(
 		"Processed config files",
 		"count",
 		filesProcessed,
@@ -140,7 +272,38 @@ func ParseConfigDirectory(dirPath string) (*Configs, error) {
 	return &configs, nil
 }
 
-// ParseConfigRemoteDirectory parse configs from blob storage
-func ParseConfigRemoteDirectory(dirPath string) (*Configs, error) {
-	return &Configs{}, nil
+// ParseConfigRemoteDirectory parses configs from a location given as a URI,
+// such as file://configs, bundle://configs.tar.gz or s3://bucket/configs. Plain
+// paths are read from the local filesystem.
+func ParseConfigRemoteDirectory(uri string, opts ...Option) (*Configs, error) {
+	fsys, err := OpenFS(uri)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfigFS(fsys, uri, opts...)
+}
+
+// Option configures how configs are parsed
+type Option func(*options)
+
+// options holds the settings applied by Option values
+type options struct {
+	profile string
+}
+
+// newOptions applies options over the defaults
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithProfile applies the overrides of a profile declared in profiles blocks,
+// such as dev or prod. An empty name applies no profile.
+func WithProfile(name string) Option {
+	return func(o *options) {
+		o.profile = name
+	}
 }
@@ -1,15 +1,41 @@
 package parser
 
 import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
+// testSettings stand in for the filesystem connector settings, which cannot
+// be imported here without an import cycle
+type testSettings struct {
+	BasePath  string `yaml:"base_path"`
+	Partition string `yaml:"partition"`
+	Password  string `yaml:"password,omitempty"`
+	Price     string `yaml:"price,omitempty"`
+}
+
+func (s *testSettings) Validate() error {
+	if s.BasePath == "" {
+		return fmt.Errorf("base_path is required")
+	}
+	return nil
+}
+
 func init() {
 	path, _ := os.Getwd()
 	os.MkdirAll(filepath.Join(path, "../../raw"), 0755)
 	os.MkdirAll(filepath.Join(path, "../../ingested"), 0755)
+
+	RegisterConnectorType("filesystem", func() ConnectorSettings {
+		return &testSettings{Partition: "daily"}
+	})
 }
 
 func TestParseConfigFile(t *testing.T) {
@@ -55,6 +81,8 @@ data_source:
       data_type: string
     - label: name
       data_type: string
+    - label: updated_at
+      data_type: timestamp
 `
 	if _, err := tempFile.Write([]byte(testConfig)); err != nil {
 		t.Fatalf("Failed to write to temp file: %v", err)
@@ -95,8 +123,8 @@ data_source:
 	if ds.Trigger.Cron != "0 0 * * *" {
 		t.Errorf("Expected cron '0 0 * * *', got '%s'", ds.Trigger.Cron)
 	}
-	if len(ds.Fields) != 2 {
-		t.Errorf("Expected 2 fields, got %d", len(ds.Fields))
+	if len(ds.Fields) != 3 {
+		t.Errorf("Expected 3 fields, got %d", len(ds.Fields))
 	}
 
 	// Test with non-existent file
@@ -126,7 +154,7 @@ connectors:
     type: filesystem
     base_path: ../../ingested
     partition: daily
-data_sources:
+data_source:
   domain: test
   name: users
   source: 
@@ -149,6 +177,8 @@ data_sources:
       data_type: string
     - label: name
       data_type: string
+    - label: updated_at
+      data_type: timestamp
 `
 	config1Path := filepath.Join(tempDir, "config1.yaml")
 	if err := os.WriteFile(config1Path, []byte(config1), 0644); err != nil {
@@ -167,7 +197,7 @@ connectors:
     type: filesystem
     base_path: ../../ingested
     partition: daily
-data_sources:
+data_source:
   domain: test
   name: products
   source: 
@@ -192,6 +222,8 @@ data_sources:
       data_type: string
     - label: price
       data_type: float
+    - label: updated_at
+      data_type: timestamp
 `
 
 	config2Path := filepath.Join(tempDir, "nested", "config2.yaml")
@@ -240,3 +272,683 @@ data_sources:
 		t.Error("ParseConfigDirectory() with empty directory should return error")
 	}
 }
+
+func TestValidate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-validate-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	broken := `id: broken
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_source:
+  domain: test
+  name: users
+  colour: blue
+  source:
+    connector: landing
+  destination:
+    connector: source
+  trigger:
+    cron: "every minute"
+  validate:
+    not_null: [id]
+    unique: [email]
+  retry:
+    max_attempts: 3
+    backoff: linear
+    retry_on: [connector, timeout]
+  timeout: -5m
+  fields:
+    - label: id
+      data_type: uuid
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "broken.yaml"), []byte(broken), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, err = ParseConfigDirectory(tempDir)
+	if err == nil {
+		t.Fatal("ParseConfigDirectory() with broken config should return error")
+	}
+
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %T: %v", err, err)
+	}
+
+	// Every problem is reported with its position
+	expected := map[string]int{
+		"data_source.colour":             10,
+		"data_source.source.connector":   12,
+		"data_source.trigger.cron":       16,
+		"data_source.validate.unique.0":  19,
+		"data_source.retry.backoff":      22,
+		"data_source.retry.retry_on.1":   23,
+		"data_source.timeout":            24,
+		"data_source.fields.0.data_type": 27,
+	}
+	for _, issue := range verr.Issues {
+		line, ok := expected[issue.Path]
+		if !ok {
+			t.Errorf("Unexpected issue: %s", issue)
+			continue
+		}
+		if issue.Line != line {
+			t.Errorf("Issue %s reported at line %d, want %d", issue.Path, issue.Line, line)
+		}
+		if issue.File != filepath.Join(tempDir, "broken.yaml") {
+			t.Errorf("Issue %s reported in file %s", issue.Path, issue.File)
+		}
+		delete(expected, issue.Path)
+	}
+	for path := range expected {
+		t.Errorf("Expected issue for %s", path)
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		dataType  string
+		precision int32
+		scale     int32
+		ok        bool
+	}{
+		{dataType: "decimal(10,2)", precision: 10, scale: 2, ok: true},
+		{dataType: "DECIMAL(38, 0)", precision: 38, scale: 0, ok: true},
+		{dataType: "decimal"},
+		{dataType: "decimal(39,2)"},
+		{dataType: "decimal(2,3)"},
+		{dataType: "decimal(0,0)"},
+		{dataType: "double"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dataType, func(t *testing.T) {
+			precision, scale, ok := ParseDecimal(tt.dataType)
+			if ok != tt.ok || precision != tt.precision || scale != tt.scale {
+				t.Errorf("ParseDecimal() = %d, %d, %v, want %d, %d, %v", precision, scale, ok, tt.precision, tt.scale, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-dependencies-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	config := func(id string, trigger string) string {
+		return `id: ` + id + `
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_source:
+  domain: sales
+  name: ` + id + `
+  source:
+    connector: source
+  destination:
+    connector: source
+  trigger:
+    ` + trigger + `
+  fields:
+    - label: id
+      data_type: string
+`
+	}
+
+	tests := []struct {
+		name     string
+		configs  map[string]string
+		expected []string
+	}{
+		{
+			name: "dag",
+			configs: map[string]string{
+				"customers": `cron: "0 * * * *"`,
+				"orders":    `cron: "0 * * * *"`,
+				"invoices":  `depends_on: [customers, orders]`,
+				"reports":   `depends_on: [invoices]`,
+			},
+		},
+		{
+			name: "unknown and self",
+			configs: map[string]string{
+				"orders":   `depends_on: [orders]`,
+				"invoices": `depends_on: [payments]`,
+			},
+			expected: []string{"config cannot depend on itself", `depends on unknown config "payments"`},
+		},
+		{
+			name: "cycle",
+			configs: map[string]string{
+				"customers": `cron: "0 * * * *"`,
+				"orders":    `depends_on: [customers, invoices]`,
+				"invoices":  `depends_on: [reports]`,
+				"reports":   `depends_on: [orders]`,
+			},
+			expected: []string{"dependency cycle: invoices -> reports -> orders -> invoices"},
+		},
+		{
+			name: "cron and depends_on",
+			configs: map[string]string{
+				"customers": `cron: "0 * * * *"`,
+				"orders":    "cron: \"0 * * * *\"\n    depends_on: [customers]",
+			},
+			expected: []string{"depends_on cannot be combined with cron"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(tempDir, strings.ReplaceAll(tt.name, " ", "-"))
+			os.Mkdir(dir, 0755)
+			for id, trigger := range tt.configs {
+				if err := os.WriteFile(filepath.Join(dir, id+".yaml"), []byte(config(id, trigger)), 0644); err != nil {
+					t.Fatalf("Failed to write config: %v", err)
+				}
+			}
+
+			_, err := ParseConfigDirectory(dir)
+			if len(tt.expected) == 0 {
+				if err != nil {
+					t.Errorf("ParseConfigDirectory() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("ParseConfigDirectory() should return error")
+			}
+			for _, message := range tt.expected {
+				if !strings.Contains(err.Error(), message) {
+					t.Errorf("Expected error containing %q, got %v", message, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExampleConfigs(t *testing.T) {
+	if _, err := ParseConfigDirectory("../../configs"); err != nil {
+		t.Errorf("Example configs should be valid: %v", err)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-interpolate-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	secretPath := filepath.Join(tempDir, "secret")
+	if err := os.WriteFile(secretPath, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	t.Setenv("MDF_TEST_ROOT", "/data")
+	t.Setenv("MDF_TEST_EMPTY", "")
+
+	config := `id: interpolated
+connectors:
+  source:
+    type: filesystem
+    base_path: ${MDF_TEST_ROOT}/raw
+    partition: ${MDF_TEST_EMPTY:-daily}
+    password: ${file:` + secretPath + `}
+    price: $$5
+data_source:
+  domain: test
+  name: users
+  source:
+    connector: source
+  destination:
+    connector: source
+  fields:
+    - label: id
+      data_type: string
+`
+	configPath := filepath.Join(tempDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	c, err := ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+
+	source := *c.Connectors["source"].Settings.(*testSettings)
+	expected := testSettings{BasePath: "/data/raw", Partition: "daily", Password: "hunter2", Price: "$5"}
+	if source != expected {
+		t.Errorf("Source settings = %+v, want %+v", source, expected)
+	}
+
+	// Resolved values never reach logs or printed output
+	rendered := c.String()
+	if strings.Contains(rendered, "hunter2") || strings.Contains(rendered, "/data/raw") {
+		t.Errorf("String() leaked a resolved secret:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, "$5") {
+		t.Errorf("String() should keep literal values:\n%s", rendered)
+	}
+
+	if origin, _ := c.Origin("connectors.source.base_path"); origin.Source != ORIGIN_ENV {
+		t.Errorf("Origin(base_path) = %v, want an %s origin", origin, ORIGIN_ENV)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("config", "config", c)
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("Logged config leaked a resolved secret: %s", buf.String())
+	}
+
+	// Missing required variables are reported with their position
+	missing := strings.Replace(config, "${MDF_TEST_ROOT}", "${MDF_TEST_UNSET}", 1)
+	if err := os.WriteFile(configPath, []byte(missing), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, err = ParseConfigFile(configPath)
+	if err == nil {
+		t.Fatal("ParseConfigFile() with unset variable should return error")
+	}
+	if !strings.Contains(err.Error(), "config.yaml:5:16: connectors.source.base_path: environment variable MDF_TEST_UNSET is not set") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// An empty variable is set, only a default replaces it
+	empty := strings.Replace(config, "${MDF_TEST_ROOT}/raw", "raw${MDF_TEST_EMPTY}", 1)
+	if err := os.WriteFile(configPath, []byte(empty), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	c, err = ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() with empty variable error = %v", err)
+	}
+	if basePath := c.Connectors["source"].Settings.(*testSettings).BasePath; basePath != "raw" {
+		t.Errorf("BasePath = %q, want raw", basePath)
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-inherit-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"_defaults.yaml": `connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_source:
+  source:
+    connector: source
+  destination:
+    connector: source
+  trigger:
+    cron: "0 0 * * *"
+`,
+		"hourly/_defaults.yaml": `connectors:
+  source:
+    partition: hourly
+data_source:
+  trigger:
+    cron: "0 * * * *"
+`,
+		"hourly/_base.yaml": `data_source:
+  domain: test
+  fields:
+    - label: id
+      data_type: string
+`,
+		"hourly/users.yaml": `id: users
+extends: _base.yaml
+data_source:
+  name: users
+  trigger:
+    random_offset: true
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	configs, err := ParseConfigDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("ParseConfigDirectory() error = %v", err)
+	}
+	if len(*configs) != 1 {
+		t.Fatalf("Expected 1 config, got %d", len(*configs))
+	}
+
+	c := (*configs)[0]
+	source := c.Connectors["source"].Settings.(*testSettings)
+	if source.BasePath != "raw" || source.Partition != "hourly" {
+		t.Errorf("Unexpected merged source connector: %+v", source)
+	}
+	if c.DataSource.Domain != "test" || c.DataSource.Name != "users" {
+		t.Errorf("Unexpected merged data source: %+v", c.DataSource)
+	}
+	if c.DataSource.Trigger.Cron != "0 * * * *" || !c.DataSource.Trigger.RandomOffset {
+		t.Errorf("Unexpected merged trigger: %+v", c.DataSource.Trigger)
+	}
+
+	// Each value records where it came from
+	origins := map[string]Origin{
+		"connectors.source.base_path":       {Source: ORIGIN_DEFAULTS, File: "_defaults.yaml", Line: 4},
+		"connectors.source.partition":       {Source: ORIGIN_DEFAULTS, File: "hourly/_defaults.yaml", Line: 3},
+		"data_source.domain":                {Source: ORIGIN_FILE, File: "hourly/_base.yaml", Line: 2},
+		"data_source.trigger.cron":          {Source: ORIGIN_DEFAULTS, File: "hourly/_defaults.yaml", Line: 6},
+		"data_source.trigger.random_offset": {Source: ORIGIN_FILE, File: "hourly/users.yaml", Line: 6},
+	}
+	for path, want := range origins {
+		want.File = filepath.Join(tempDir, want.File)
+		if got, ok := c.Origin(path); !ok || got != want {
+			t.Errorf("Origin(%s) = %v, want %v", path, got, want)
+		}
+	}
+	if got, ok := c.Origin("data_source.trigger.depends_on"); ok {
+		t.Errorf("Origin() of an undeclared value = %v, want none", got)
+	}
+
+	// Problems in shared files are reported once, against the shared file
+	broken := files["hourly/_defaults.yaml"] + "  colour: blue\n"
+	os.WriteFile(filepath.Join(tempDir, "hourly/_defaults.yaml"), []byte(broken), 0644)
+	os.WriteFile(filepath.Join(tempDir, "hourly/orders.yaml"), []byte(strings.ReplaceAll(files["hourly/users.yaml"], "users", "orders")), 0644)
+
+	_, err = ParseConfigDirectory(tempDir)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %T: %v", err, err)
+	}
+	if len(verr.Issues) != 1 || verr.Issues[0].File != filepath.Join(tempDir, "hourly/_defaults.yaml") || verr.Issues[0].Line != 7 {
+		t.Errorf("Unexpected issues: %v", verr)
+	}
+
+	// Extends cycles are rejected
+	os.WriteFile(filepath.Join(tempDir, "hourly/_base.yaml"), []byte("extends: users.yaml\n"), 0644)
+	_, err = ParseConfigDirectory(tempDir)
+	if err == nil || !strings.Contains(err.Error(), "extends cycle") {
+		t.Errorf("Expected extends cycle error, got %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-profiles-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"_defaults.yaml": `connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_source:
+  source:
+    connector: source
+  destination:
+    connector: source
+profiles:
+  dev:
+    data_source:
+      trigger:
+        cron: ""
+`,
+		"hourly/_defaults.yaml": `data_source:
+  trigger:
+    cron: "0 * * * *"
+`,
+		"hourly/users.yaml": `id: users
+data_source:
+  domain: test
+  name: users
+  validate:
+    not_null: [id]
+  fields:
+    - label: id
+      data_type: string
+    - label: email
+      data_type: string
+profiles:
+  prod:
+    connectors:
+      source:
+        base_path: s3-mount/raw
+    data_source:
+      validate:
+        not_null: [id, email]
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		profile  string
+		basePath string
+		cron     string
+		notNull  []string
+	}{
+		{profile: "", basePath: "raw", cron: "0 * * * *", notNull: []string{"id"}},
+		{profile: "dev", basePath: "raw", cron: "", notNull: []string{"id"}},
+		{profile: "prod", basePath: "s3-mount/raw", cron: "0 * * * *", notNull: []string{"id", "email"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.profile, "none"), func(t *testing.T) {
+			configs, err := ParseConfigDirectory(tempDir, WithProfile(tt.profile))
+			if err != nil {
+				t.Fatalf("ParseConfigDirectory() error = %v", err)
+			}
+
+			c := (*configs)[0]
+			if basePath := c.Connectors["source"].Settings.(*testSettings).BasePath; basePath != tt.basePath {
+				t.Errorf("base_path = %q, want %q", basePath, tt.basePath)
+			}
+			if c.DataSource.Trigger.Cron != tt.cron {
+				t.Errorf("cron = %q, want %q", c.DataSource.Trigger.Cron, tt.cron)
+			}
+			if !slices.Equal(c.DataSource.Validate.NotNull, tt.notNull) {
+				t.Errorf("not_null = %v, want %v", c.DataSource.Validate.NotNull, tt.notNull)
+			}
+
+			// Overridden values come from the profile
+			want := Origin{Source: ORIGIN_DEFAULTS, File: filepath.Join(tempDir, "_defaults.yaml"), Line: 4}
+			if tt.profile == "prod" {
+				want = Origin{Source: ORIGIN_PROFILE, Profile: "prod", File: filepath.Join(tempDir, "hourly/users.yaml"), Line: 16}
+			}
+			if got, _ := c.Origin("connectors.source.base_path"); got != want {
+				t.Errorf("Origin(base_path) = %v, want %v", got, want)
+			}
+		})
+	}
+
+	// Profiles that no file declares are rejected
+	if _, err := ParseConfigDirectory(tempDir, WithProfile("staging")); err == nil || !strings.Contains(err.Error(), `profile "staging" is not declared`) {
+		t.Errorf("Expected undeclared profile error, got %v", err)
+	}
+
+	// Unknown keys in any profile are reported, even when it is not selected
+	broken := strings.Replace(files["hourly/users.yaml"], "not_null: [id, email]", "not_nul: [id, email]", 1)
+	os.WriteFile(filepath.Join(tempDir, "hourly/users.yaml"), []byte(broken), 0644)
+	_, err = ParseConfigDirectory(tempDir)
+	if err == nil || !strings.Contains(err.Error(), "profiles.prod.data_source.validate.not_nul") {
+		t.Errorf("Expected unknown profile key error, got %v", err)
+	}
+}
+
+func TestMultipleDataSources(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-multi-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	multi := `id: orders
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_source:
+  domain: sales
+  name: orders
+  source:
+    connector: source
+  destination:
+    connector: source
+  fields:
+    - label: id
+      data_type: string
+---
+id: tables
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partition: daily
+data_sources:
+  - domain: sales
+    name: customers
+    source:
+      connector: source
+    destination:
+      connector: source
+    fields:
+      - label: id
+        data_type: string
+  - domain: sales
+    name: products
+    source:
+      connector: source
+    destination:
+      connector: source
+    fields:
+      - label: id
+        data_type: string
+---
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "sales.yaml"), []byte(multi), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	configs, err := ParseConfigDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("ParseConfigDirectory() error = %v", err)
+	}
+
+	var ids []string
+	for _, c := range *configs {
+		ids = append(ids, c.Id)
+	}
+	expected := []string{"orders", "tables.customers", "tables.products"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Config ids = %v, want %v", ids, expected)
+	}
+
+	// Duplicate ids are detected across documents and files
+	duplicate := strings.Replace(multi, "name: products", "name: customers", 1)
+	if err := os.WriteFile(filepath.Join(tempDir, "sales.yaml"), []byte(duplicate), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := ParseConfigDirectory(tempDir); err == nil || !strings.Contains(err.Error(), `"tables.customers" already exists`) {
+		t.Errorf("Expected duplicate id error, got %v", err)
+	}
+
+	other := multi[:strings.Index(multi, "---")]
+	os.WriteFile(filepath.Join(tempDir, "sales.yaml"), []byte(multi), 0644)
+	os.WriteFile(filepath.Join(tempDir, "other.yaml"), []byte(other), 0644)
+	if _, err := ParseConfigDirectory(tempDir); err == nil || !strings.Contains(err.Error(), `"orders" already exists`) {
+		t.Errorf("Expected duplicate id error, got %v", err)
+	}
+
+	// A single config file cannot declare several configs
+	if _, err := ParseConfigFile(filepath.Join(tempDir, "sales.yaml")); err == nil {
+		t.Error("ParseConfigFile() with several configs should return error")
+	}
+}
+
+func TestConnectorSettings(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "config-connectors-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	config := `id: connectors
+connectors:
+  source:
+    type: filesystem
+    base_path: raw
+    partitoin: daily
+  destination:
+    type: filesystem
+    partition: daily
+  remote:
+    type: ftp
+data_source:
+  domain: test
+  name: users
+  source:
+    connector: source
+  destination:
+    connector: destination
+  fields:
+    - label: id
+      data_type: string
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, err = ParseConfigDirectory(tempDir)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %T: %v", err, err)
+	}
+
+	expected := map[string]int{
+		"connectors.source.partitoin": 6,
+		"connectors.destination":      8,
+		"connectors.remote.type":      11,
+	}
+	for _, issue := range verr.Issues {
+		line, ok := expected[issue.Path]
+		if !ok {
+			t.Errorf("Unexpected issue: %s", issue)
+			continue
+		}
+		if issue.Line != line {
+			t.Errorf("Issue %s reported at line %d, want %d", issue.Path, issue.Line, line)
+		}
+		delete(expected, issue.Path)
+	}
+	for path := range expected {
+		t.Errorf("Expected issue for %s", path)
+	}
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// durationPattern matches Go durations such as 30s or 1h30m
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// SchemaEnumer is implemented by config types with string fields that only
+// accept a fixed set of values, keyed by yaml key
+type SchemaEnumer interface {
+	SchemaEnums() map[string][]string
+}
+
+// SchemaPatterner is implemented by config types with string fields that
+// also accept values matching a pattern, keyed by yaml key
+type SchemaPatterner interface {
+	SchemaPatterns() map[string]string
+}
+
+// SchemaEnums implements SchemaEnumer
+func (FieldConfig) SchemaEnums() map[string][]string {
+	return map[string][]string{"data_type": DataTypes}
+}
+
+// SchemaPatterns implements SchemaPatterner
+func (FieldConfig) SchemaPatterns() map[string]string {
+	return map[string]string{"data_type": decimalPattern}
+}
+
+// SchemaEnums implements SchemaEnumer
+func (RetryConfig) SchemaEnums() map[string][]string {
+	return map[string][]string{"backoff": Backoffs, "retry_on": ErrorClasses}
+}
+
+// JSONSchema returns a JSON Schema for config files generated from the config
+// types and the registered connector settings. Every key is optional so the
+// schema also applies to partial files such as _defaults.yaml.
+func JSONSchema() ([]byte, error) {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root := g.object(reflect.TypeFor[Config]())
+
+	// A data_sources list may replace data_source, see expand
+	root["properties"].(map[string]any)["data_sources"] = map[string]any{
+		"type":  "array",
+		"items": g.schema(reflect.TypeFor[DataSource]()),
+	}
+
+	// Profiles override any part of the config
+	root["properties"].(map[string]any)["profiles"] = map[string]any{
+		"type":                 "object",
+		"additionalProperties": map[string]any{"$ref": "#"},
+	}
+
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "mdf config"
+	root["$defs"] = g.defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// schemaGenerator builds schemas for Go types, collecting named structs as
+// definitions
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// schema returns the schema of a type
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeFor[ConnectorConfig]() {
+		return g.connector()
+	}
+	if t == reflect.TypeFor[time.Duration]() {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // Reserve the name for recursive types
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of a struct from its yaml tags
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var enums map[string][]string
+	if e, ok := reflect.New(t).Interface().(SchemaEnumer); ok {
+		enums = e.SchemaEnums()
+	}
+	var patterns map[string]string
+	if p, ok := reflect.New(t).Interface().(SchemaPatterner); ok {
+		patterns = p.SchemaPatterns()
+	}
+
+	properties := make(map[string]any)
+	for key, field := range yamlFields(t) {
+		property := g.schema(field.Type)
+		if values, ok := enums[key]; ok {
+			// Lists restrict their items
+			if items, ok := property["items"].(map[string]any); ok {
+				items["enum"] = values
+			} else if pattern, ok := patterns[key]; ok {
+				property["anyOf"] = []any{
+					map[string]any{"enum": values},
+					map[string]any{"pattern": pattern},
+				}
+			} else {
+				property["enum"] = values
+			}
+		}
+		properties[key] = property
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// connector returns the schema of a connector declaration. The type may be
+// inherited, so any registered setting is accepted and settings are checked
+// against the type whenever it is present.
+func (g *schemaGenerator) connector() map[string]any {
+	types := ConnectorTypes()
+	properties := map[string]any{
+		"type": map[string]any{"type": "string", "enum": types},
+	}
+
+	var conditions []any
+	for _, name := range types {
+		settings, _ := NewConnectorSettings(name)
+		object := g.object(reflect.TypeOf(settings))
+		typed := object["properties"].(map[string]any)
+		for key, property := range typed {
+			properties[key] = property
+		}
+		typed["type"] = map[string]any{"const": name}
+
+		conditions = append(conditions, map[string]any{
+			"if": map[string]any{
+				"properties": map[string]any{"type": map[string]any{"const": name}},
+				"required":   []string{"type"},
+			},
+			"then": object,
+		})
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(conditions) > 0 {
+		schema["allOf"] = conditions
+	}
+	schema["description"] = "Connector settings, one of: " + strings.Join(types, ", ")
+	return schema
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema() error = %v", err)
+	}
+
+	var schema struct {
+		Properties           map[string]json.RawMessage `json:"properties"`
+		AdditionalProperties bool                       `json:"additionalProperties"`
+		Defs                 map[string]struct {
+			Properties map[string]struct {
+				Type  string   `json:"type"`
+				Enum  []string `json:"enum"`
+				AnyOf []struct {
+					Enum    []string `json:"enum"`
+					Pattern string   `json:"pattern"`
+				} `json:"anyOf"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("JSONSchema() returned invalid JSON: %v", err)
+	}
+
+	for _, key := range []string{"id", "extends", "connectors", "data_source", "data_sources", "profiles"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("Expected property %s in schema", key)
+		}
+	}
+	if schema.AdditionalProperties {
+		t.Error("Unknown keys should be rejected")
+	}
+
+	for _, def := range []string{"DataSource", "SourceConfig", "DestinationConfig", "TriggerConfig", "ValidationConfig", "RetryConfig", "FieldConfig"} {
+		if _, ok := schema.Defs[def]; !ok {
+			t.Errorf("Expected definition %s in schema", def)
+		}
+	}
+
+	dataType := schema.Defs["FieldConfig"].Properties["data_type"]
+	// Decimals may also declare their precision and scale
+	if len(dataType.AnyOf) != 2 || !slices.Equal(dataType.AnyOf[0].Enum, DataTypes) || dataType.AnyOf[1].Pattern != decimalPattern {
+		t.Errorf("data_type = %+v, want the enum %v or the decimal pattern", dataType.AnyOf, DataTypes)
+	}
+	if delay := schema.Defs["RetryConfig"].Properties["delay"]; delay.Type != "string" {
+		t.Errorf("delay type = %q, want string durations", delay.Type)
+	}
+	if timeout := schema.Defs["DataSource"].Properties["timeout"]; timeout.Type != "string" {
+		t.Errorf("timeout type = %q, want string durations", timeout.Type)
+	}
+
+	// Connector settings come from the registered connector types
+	var connectors struct {
+		AdditionalProperties struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			AllOf      []struct {
+				Then struct {
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"then"`
+			} `json:"allOf"`
+		} `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(schema.Properties["connectors"], &connectors); err != nil {
+		t.Fatalf("Failed to decode connectors schema: %v", err)
+	}
+	for _, key := range []string{"type", "base_path", "partition"} {
+		if _, ok := connectors.AdditionalProperties.Properties[key]; !ok {
+			t.Errorf("Expected connector property %s in schema", key)
+		}
+	}
+	if len(connectors.AdditionalProperties.AllOf) != len(ConnectorTypes()) {
+		t.Errorf("Expected a condition per connector type, got %d", len(connectors.AdditionalProperties.AllOf))
+	}
+}
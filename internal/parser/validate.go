@@ -0,0 +1,546 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Retry backoff strategies
+const (
+	BACKOFF_FIXED       = "fixed"
+	BACKOFF_EXPONENTIAL = "exponential"
+	BACKOFF_JITTER      = "jitter"
+)
+
+// Backoffs lists the supported retry backoff strategies
+var Backoffs = []string{BACKOFF_FIXED, BACKOFF_EXPONENTIAL, BACKOFF_JITTER}
+
+// Classes of job errors, connector errors come from reading or writing a
+// resource and validation errors from data that failed its checks
+const (
+	CONNECTOR_ERROR  = "connector"
+	VALIDATION_ERROR = "validation"
+)
+
+// ErrorClasses lists the error classes a retry policy can retry
+var ErrorClasses = []string{CONNECTOR_ERROR, VALIDATION_ERROR}
+
+// DataTypes lists the supported field data types
+var DataTypes = []string{
+	"bigint",
+	"bool",
+	"boolean",
+	"date",
+	"decimal",
+	"double",
+	"float",
+	"int",
+	"integer",
+	"string",
+	"timestamp",
+	"varchar",
+}
+
+// decimalPattern matches a decimal data type with its precision and scale,
+// such as decimal(10,2)
+const decimalPattern = `^decimal\(\s*([0-9]+)\s*,\s*([0-9]+)\s*\)$`
+
+var decimalType = regexp.MustCompile(decimalPattern)
+
+// ParseDecimal returns the precision and scale of a decimal(p,s) data type,
+// ok is false when the data type is not one or is out of DuckDB's range
+func ParseDecimal(dataType string) (precision, scale int32, ok bool) {
+	match := decimalType.FindStringSubmatch(strings.ToLower(dataType))
+	if match == nil {
+		return 0, 0, false
+	}
+	p, err := strconv.Atoi(match[1])
+	if err != nil || p < 1 || p > 38 {
+		return 0, 0, false
+	}
+	s, err := strconv.Atoi(match[2])
+	if err != nil || s > p {
+		return 0, 0, false
+	}
+	return int32(p), int32(s), true
+}
+
+// validDataType reports whether a field data type is supported
+func validDataType(dataType string) bool {
+	if _, _, ok := ParseDecimal(dataType); ok {
+		return true
+	}
+	return slices.Contains(DataTypes, strings.ToLower(dataType))
+}
+
+// Issue describes a single problem found in a config file
+type Issue struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+// String formats the issue as file:line:column: path: message
+func (i Issue) String() string {
+	var b strings.Builder
+	if i.File != "" {
+		b.WriteString(i.File)
+		if i.Line > 0 {
+			fmt.Fprintf(&b, ":%d", i.Line)
+			if i.Column > 0 {
+				fmt.Fprintf(&b, ":%d", i.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	if i.Path != "" {
+		b.WriteString(i.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// ValidationError reports every issue found while validating configs
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d issues):", len(e.Issues)))
+	for _, issue := range e.Issues {
+		lines = append(lines, "  "+issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// issues accumulates validation issues for a single document
+type issues struct {
+	doc  *document
+	list []Issue
+}
+
+// add records an issue located at the given dotted path
+func (is *issues) add(path string, format string, args ...any) {
+	issue := Issue{Path: path, Message: fmt.Sprintf(format, args...)}
+	if is.doc != nil {
+		issue.File = is.doc.file
+		if node := is.doc.locate(path); node != nil {
+			issue.File = is.doc.fileOf(node)
+			issue.Line, issue.Column = node.Line, node.Column
+		}
+	}
+	is.list = append(is.list, issue)
+}
+
+// err returns the accumulated issues as an error, or nil when there are none
+func (is *issues) err() error {
+	if len(is.list) == 0 {
+		return nil
+	}
+	return &ValidationError{Issues: is.list}
+}
+
+// document is a parsed YAML config document
+type document struct {
+	file string
+	root *yaml.Node
+	// files maps nodes merged from other files to the file they came from
+	files map[*yaml.Node]string
+	// secrets holds the paths of values resolved through interpolation
+	secrets []string
+	// profiles maps nodes merged from a profiles block to the profile
+	profiles map[*yaml.Node]string
+}
+
+// fileOf returns the file a node was declared in
+func (d *document) fileOf(node *yaml.Node) string {
+	if file, ok := d.files[node]; ok {
+		return file
+	}
+	return d.file
+}
+
+// locate returns the node at a dotted path such as data_source.fields.0.label,
+// or the deepest existing ancestor when the path is not present
+func (d *document) locate(path string) *yaml.Node {
+	node := d.root
+	if node == nil || path == "" {
+		return node
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		next := child(node, key)
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// lookup returns the node at a dotted path, or nil when it is not present
+func lookup(node *yaml.Node, path string) *yaml.Node {
+	for _, key := range strings.Split(path, ".") {
+		if node = child(node, key); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// child returns the value of a mapping key or the item of a sequence index
+func child(node *yaml.Node, key string) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(key)
+		if err == nil && i >= 0 && i < len(node.Content) {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// checkKnownFields reports mapping keys that do not match a yaml tag of the
+// struct they are decoded into
+func checkKnownFields(is *issues, node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	if t == reflect.TypeFor[ConnectorConfig]() {
+		checkConnectorFields(is, node, path)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			field, ok := fields[key.Value]
+			if !ok {
+				is.list = append(is.list, Issue{
+					File:    is.doc.fileOf(key),
+					Line:    key.Line,
+					Column:  key.Column,
+					Path:    join(key.Value),
+					Message: fmt.Sprintf("unknown key %q", key.Value),
+				})
+				continue
+			}
+			checkKnownFields(is, node.Content[i+1], field.Type, join(key.Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkKnownFields(is, item, t.Elem(), join(strconv.Itoa(i)))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKnownFields(is, node.Content[i+1], t.Elem(), join(node.Content[i].Value))
+		}
+	}
+}
+
+// yamlFields maps the yaml keys of a struct to its fields
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// decodeErrorLine matches the line prefix of yaml decode errors
+var decodeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decodeIssues converts a yaml decode error of a file into issues
+func decodeIssues(is *issues, file string, err error) {
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	for _, message := range messages {
+		issue := Issue{File: file, Message: message}
+		if m := decodeErrorLine.FindStringSubmatch(message); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Message = m[2]
+		}
+		is.list = append(is.list, issue)
+	}
+}
+
+// Validate checks a config for semantic problems and reports all of them
+func (c *Config) Validate() error {
+	is := &issues{doc: c.doc}
+	c.validate(is)
+	return is.err()
+}
+
+// validate records the issues of a single config
+func (c *Config) validate(is *issues) {
+	if c.Id == "" {
+		is.add("id", "id is required")
+	}
+
+	if len(c.Connectors) == 0 {
+		is.add("connectors", "at least one connector is required")
+	}
+	for _, name := range sortedKeys(c.Connectors) {
+		c.Connectors[name].validate(is, "connectors."+name)
+	}
+
+	ds := c.DataSource
+	if ds.Domain == "" {
+		is.add("data_source.domain", "domain is required")
+	}
+	if ds.Name == "" {
+		is.add("data_source.name", "name is required")
+	}
+
+	c.validateConnectorRef(is, "data_source.source.connector", ds.Source.Connector)
+	c.validateConnectorRef(is, "data_source.destination.connector", ds.Destination.Connector)
+
+	// Fields must be declared once with a supported data type
+	if len(ds.Fields) == 0 {
+		is.add("data_source.fields", "at least one field is required")
+	}
+	var labels []string
+	for i, field := range ds.Fields {
+		path := fmt.Sprintf("data_source.fields.%d", i)
+		switch {
+		case field.Label == "":
+			is.add(path+".label", "label is required")
+		case slices.Contains(labels, field.Label):
+			is.add(path+".label", "field %q is declared more than once", field.Label)
+		default:
+			labels = append(labels, field.Label)
+		}
+		if !validDataType(field.DataType) {
+			is.add(path+".data_type", "unknown data type %q, must be one of: %v or decimal(p,s)", field.DataType, DataTypes)
+		}
+	}
+
+	// Columns referenced elsewhere must be declared fields
+	validateFieldRefs(is, "data_source.source.primary_key", ds.Source.PrimaryKey, labels)
+	if ds.Source.TimestampField != "" {
+		validateFieldRef(is, "data_source.source.timestamp_field", ds.Source.TimestampField, labels)
+	}
+	validateFieldRefs(is, "data_source.validate.not_null", ds.Validate.NotNull, labels)
+	validateFieldRefs(is, "data_source.validate.unique", ds.Validate.Unique, labels)
+	for i, order := range ds.Destination.Ordering {
+		path := fmt.Sprintf("data_source.destination.ordering.%d", i)
+		parts := strings.Fields(order)
+		if len(parts) == 0 || len(parts) > 2 {
+			is.add(path, "ordering %q must be '<field> [asc|desc]'", order)
+			continue
+		}
+		validateFieldRef(is, path, parts[0], labels)
+		if len(parts) == 2 && !slices.Contains([]string{"asc", "desc"}, strings.ToLower(parts[1])) {
+			is.add(path, "ordering direction %q must be asc or desc", parts[1])
+		}
+	}
+
+	if ds.Trigger.Cron != "" {
+		if _, err := cron.ParseStandard(ds.Trigger.Cron); err != nil {
+			is.add("data_source.trigger.cron", "invalid cron expression %q: %v", ds.Trigger.Cron, err)
+		}
+		if len(ds.Trigger.DependsOn) > 0 {
+			is.add("data_source.trigger.depends_on", "depends_on cannot be combined with cron, dependent jobs run when their upstreams succeed")
+		}
+	}
+
+	retry := ds.Retry
+	if retry.MaxAttempts < 0 {
+		is.add("data_source.retry.max_attempts", "max_attempts must not be negative")
+	}
+	if retry.Backoff != "" && !slices.Contains(Backoffs, retry.Backoff) {
+		is.add("data_source.retry.backoff", "unknown backoff %q, must be one of: %v", retry.Backoff, Backoffs)
+	}
+	if retry.Delay < 0 {
+		is.add("data_source.retry.delay", "delay must not be negative")
+	}
+	if retry.MaxDelay < 0 || (retry.MaxDelay > 0 && retry.MaxDelay < retry.Delay) {
+		is.add("data_source.retry.max_delay", "max_delay must not be less than delay")
+	}
+	for i, class := range retry.RetryOn {
+		if !slices.Contains(ErrorClasses, class) {
+			is.add(fmt.Sprintf("data_source.retry.retry_on.%d", i), "unknown error class %q, must be one of: %v", class, ErrorClasses)
+		}
+	}
+
+	if ds.Timeout < 0 {
+		is.add("data_source.timeout", "timeout must not be negative")
+	}
+
+	seen := make(map[string]bool)
+	for i, upstream := range ds.Trigger.DependsOn {
+		path := fmt.Sprintf("data_source.trigger.depends_on.%d", i)
+		switch {
+		case upstream == c.Id:
+			is.add(path, "config cannot depend on itself")
+		case seen[upstream]:
+			is.add(path, "duplicate dependency %q", upstream)
+		}
+		seen[upstream] = true
+	}
+}
+
+// validateConnectorRef checks that a connector reference names a declared connector
+func (c *Config) validateConnectorRef(is *issues, path string, name string) {
+	if name == "" {
+		is.add(path, "connector is required")
+		return
+	}
+	if _, ok := c.Connectors[name]; !ok {
+		is.add(path, "connector %q is not declared in connectors, must be one of: %v", name, sortedKeys(c.Connectors))
+	}
+}
+
+// validateFieldRefs checks that every column in a list is a declared field
+func validateFieldRefs(is *issues, path string, columns []string, labels []string) {
+	for i, column := range columns {
+		validateFieldRef(is, fmt.Sprintf("%s.%d", path, i), column, labels)
+	}
+}
+
+// validateFieldRef checks that a column is a declared field
+func validateFieldRef(is *issues, path string, column string, labels []string) {
+	if !slices.Contains(labels, column) {
+		is.add(path, "field %q is not declared in fields", column)
+	}
+}
+
+// Validate checks every config and that config ids are unique, reporting all
+// issues at once
+func (cs Configs) Validate() error {
+	var all []Issue
+	seen := make(map[string]*Config)
+	for i := range cs {
+		c := &cs[i]
+		is := &issues{doc: c.doc}
+		c.validate(is)
+		if first, ok := seen[c.Id]; ok && c.Id != "" {
+			is.add("id", "config with id %q already exists in %s", c.Id, first.File())
+		} else {
+			seen[c.Id] = c
+		}
+		all = append(all, is.list...)
+	}
+	all = append(all, validateDependencies(seen)...)
+
+	if len(all) == 0 {
+		return nil
+	}
+	return &ValidationError{Issues: all}
+}
+
+// validateDependencies checks that every depends_on names a known config and
+// that the dependencies form a DAG
+func validateDependencies(configs map[string]*Config) []Issue {
+	var all []Issue
+	for _, id := range sortedKeys(configs) {
+		c := configs[id]
+		is := &issues{doc: c.doc}
+		for i, upstream := range c.DataSource.Trigger.DependsOn {
+			if _, ok := configs[upstream]; !ok && upstream != id {
+				is.add(fmt.Sprintf("data_source.trigger.depends_on.%d", i), "depends on unknown config %q", upstream)
+			}
+		}
+		all = append(all, is.list...)
+	}
+
+	// Depth first search, a dependency on a config still being visited
+	// closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var stack []string
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		stack = append(stack, id)
+		c := configs[id]
+		for _, upstream := range c.DataSource.Trigger.DependsOn {
+			if _, ok := configs[upstream]; !ok || upstream == id {
+				continue
+			}
+			switch state[upstream] {
+			case visiting:
+				cycle := append(slices.Clone(stack[slices.Index(stack, upstream):]), upstream)
+				is := &issues{doc: c.doc}
+				is.add("data_source.trigger.depends_on", "dependency cycle: %s", strings.Join(cycle, " -> "))
+				all = append(all, is.list...)
+			case 0:
+				visit(upstream)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+	}
+	for _, id := range sortedKeys(configs) {
+		if state[id] == 0 {
+			visit(id)
+		}
+	}
+
+	return all
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBrokerClosed is returned when publishing to a closed broker
+var ErrBrokerClosed = errors.New("broker is closed")
+
+// Broker delivers jobs from the triggerer to the scheduler workers
+type Broker interface {
+	// Publish enqueues a job
+	Publish(ctx context.Context, job Job) error
+	// PublishDelayed enqueues a job once delay has passed, it is used to
+	// retry failed jobs
+	PublishDelayed(ctx context.Context, job Job, delay time.Duration) error
+	// Consume delivers enqueued jobs until ctx is cancelled or the broker is
+	// closed, when the channel is closed
+	Consume(ctx context.Context) (<-chan Delivery, error)
+	Close() error
+}
+
+// Delivery is a job received from a broker. Every delivery must be settled
+// with Ack once handled or Nack when it failed.
+type Delivery struct {
+	Job Job
+	// Redelivered is set when the job was requeued before
+	Redelivered bool
+	Ack         func() error
+	// Nack rejects the job, putting it back on the queue or moving it to the
+	// dead letter queue
+	Nack func(requeue bool) error
+}
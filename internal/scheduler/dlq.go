@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a message in the dead letter queue
+type DeadLetter struct {
+	Job Job
+	// MessageId identifies messages that are not valid jobs
+	MessageId string
+	// Err is set when the message is not a valid job
+	Err error
+	// Reason is why the broker dead lettered the message, such as rejected
+	Reason string
+	// Time is when the message was dead lettered
+	Time time.Time
+}
+
+// deadLetterQueue inspects and replays the jobs in the dead letter queue of a
+// rabbitmq broker
+type deadLetterQueue struct {
+	conn *amqp.Connection
+	opts RabbitMQOptions
+}
+
+// OpenDeadLetterQueue connects to the dead letter queue of a rabbitmq broker,
+// declaring the topology when it does not exist yet
+func OpenDeadLetterQueue(opts RabbitMQOptions) (*deadLetterQueue, error) {
+	if opts.DeadLetterExchange == "" {
+		return nil, errors.New("no dead letter exchange is configured, rejected jobs are dropped")
+	}
+
+	config, err := opts.amqpConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := declare(opts, config); err != nil {
+		return nil, err
+	}
+
+	conn, err := amqp.DialConfig(opts.URL, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+	return &deadLetterQueue{conn: conn, opts: opts}, nil
+}
+
+// List returns up to limit dead letters, oldest first, leaving them in the
+// queue
+func (q *deadLetterQueue) List(limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var letters []DeadLetter
+	err := q.each(func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		letters = append(letters, deadLetter(d))
+		return len(letters) < limit, nil
+	})
+	return letters, err
+}
+
+// Retry publishes the dead lettered jobs that match to the job queue with
+// their attempts reset, and removes them from the dead letter queue. It
+// returns the number of jobs retried.
+func (q *deadLetterQueue) Retry(ctx context.Context, match func(Job) bool) (int, error) {
+	retried := 0
+	err := q.each(func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		letter := deadLetter(d)
+		if letter.Err != nil || !match(letter.Job) {
+			return true, nil
+		}
+
+		job := letter.Job
+		job.Attempt = 1
+		data, err := EncodeJob(job)
+		if err != nil {
+			return false, err
+		}
+		publishing := amqp.Publishing{
+			ContentType: "application/json",
+			MessageId:   job.RunId,
+			Type:        d.Type,
+			Body:        data,
+		}
+		if q.opts.Durable {
+			publishing.DeliveryMode = amqp.Persistent
+		}
+
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, q.opts.Exchange, q.opts.Queue, true, false, publishing)
+		if err != nil {
+			return false, fmt.Errorf("failed to publish job %s: %w", job.RunId, err)
+		}
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to confirm job %s: %w", job.RunId, err)
+		}
+		if !acked {
+			return false, fmt.Errorf("broker rejected job %s", job.RunId)
+		}
+		if err := d.Ack(false); err != nil {
+			return false, fmt.Errorf("failed to remove job %s from the dead letter queue: %w", job.RunId, err)
+		}
+		retried++
+		return true, nil
+	})
+	return retried, err
+}
+
+// Purge removes the dead letters that match, or every message when match is
+// nil, and returns the number removed
+func (q *deadLetterQueue) Purge(match func(Job) bool) (int, error) {
+	if match == nil {
+		ch, err := q.conn.Channel()
+		if err != nil {
+			return 0, fmt.Errorf("failed to open rabbitmq channel: %w", err)
+		}
+		defer ch.Close()
+		return ch.QueuePurge(q.opts.deadLetterQueue(), false)
+	}
+
+	purged := 0
+	err := q.each(func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		letter := deadLetter(d)
+		if letter.Err != nil || !match(letter.Job) {
+			return true, nil
+		}
+		if err := d.Ack(false); err != nil {
+			return false, fmt.Errorf("failed to remove job %s: %w", letter.Job.RunId, err)
+		}
+		purged++
+		return true, nil
+	})
+	return purged, err
+}
+
+// Close closes the connection
+func (q *deadLetterQueue) Close() error {
+	return q.conn.Close()
+}
+
+// each gets the messages in the dead letter queue one at a time until fn
+// returns false or every message has been seen, messages dead lettered in the
+// meantime are left for the next call. Messages fn does not acknowledge
+// return to the queue when the channel closes.
+func (q *deadLetterQueue) each(fn func(ch *amqp.Channel, d amqp.Delivery) (bool, error)) error {
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open rabbitmq channel: %w", err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	// The first message tells how many follow it
+	total := -1
+	for seen := 0; total < 0 || seen < total; seen++ {
+		d, ok, err := ch.Get(q.opts.deadLetterQueue(), false)
+		if err != nil {
+			return fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+		if total < 0 {
+			total = int(d.MessageCount) + 1
+		}
+
+		more, err := fn(ch, d)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadLetter decodes a dead lettered message and its x-death header
+func deadLetter(d amqp.Delivery) DeadLetter {
+	job, err := DecodeJob(d.Body)
+	letter := DeadLetter{Job: job, MessageId: d.MessageId, Err: err}
+
+	deaths, _ := d.Headers["x-death"].([]any)
+	if len(deaths) > 0 {
+		// The most recent death, into this queue, comes first
+		if death, ok := deaths[0].(amqp.Table); ok {
+			letter.Reason, _ = death["reason"].(string)
+			letter.Time, _ = death["time"].(time.Time)
+		}
+	}
+	return letter
+}
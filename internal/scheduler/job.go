@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JOB_MESSAGE_VERSION is the version of the job message format, bumped on
+// incompatible changes
+const JOB_MESSAGE_VERSION = 1
+
+// Trigger is what caused a job to run
+type Trigger string
+
+const (
+	CRON       Trigger = "cron"
+	DEPENDENCY Trigger = "dependency"
+	MANUAL     Trigger = "manual"
+)
+
+// Job is a single run of a config
+type Job struct {
+	ConfigId string `json:"config_id"`
+	// RunId identifies the run across attempts
+	RunId string `json:"run_id"`
+	// LogicalTime is the start of the interval the run covers. Downstream
+	// jobs run for the same logical time as their upstreams.
+	LogicalTime time.Time `json:"logical_time"`
+	Trigger     Trigger   `json:"trigger"`
+	// Attempt counts the runs of the job, starting at 1
+	Attempt int `json:"attempt"`
+}
+
+// NewJob creates the first attempt of a run of a config
+func NewJob(configId string, logicalTime time.Time, trigger Trigger) Job {
+	return Job{
+		ConfigId:    configId,
+		RunId:       uuid.New().String(),
+		LogicalTime: logicalTime,
+		Trigger:     trigger,
+		Attempt:     1,
+	}
+}
+
+// jobMessage is the versioned wire format of a job
+type jobMessage struct {
+	Version int `json:"version"`
+	Job
+}
+
+// EncodeJob encodes a job as a versioned JSON message
+func EncodeJob(job Job) ([]byte, error) {
+	data, err := json.Marshal(jobMessage{Version: JOB_MESSAGE_VERSION, Job: job})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeJob decodes a job message, rejecting versions it does not understand
+func DecodeJob(data []byte) (Job, error) {
+	var message jobMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return Job{}, fmt.Errorf("failed to decode job: %w", err)
+	}
+	if message.Version != JOB_MESSAGE_VERSION {
+		return Job{}, fmt.Errorf("unsupported job message version %d, expected %d", message.Version, JOB_MESSAGE_VERSION)
+	}
+	if message.ConfigId == "" {
+		return Job{}, fmt.Errorf("job message has no config_id")
+	}
+	return message.Job, nil
+}
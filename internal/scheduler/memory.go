@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memoryBroker is an in-process broker backed by a buffered channel. Jobs do
+// not survive a restart, it is meant for local development and tests.
+type memoryBroker struct {
+	queue     chan envelope
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	dead []Job
+}
+
+// envelope is a queued job
+type envelope struct {
+	job         Job
+	redelivered bool
+}
+
+// NewMemoryBroker creates an in-process broker holding up to capacity queued
+// jobs, Publish blocks while the queue is full
+func NewMemoryBroker(capacity int) *memoryBroker {
+	return &memoryBroker{
+		queue: make(chan envelope, capacity),
+		done:  make(chan struct{}),
+	}
+}
+
+// Publish enqueues a job
+func (b *memoryBroker) Publish(ctx context.Context, job Job) error {
+	return b.enqueue(ctx, envelope{job: job})
+}
+
+// PublishDelayed enqueues a job once delay has passed, jobs still waiting
+// when the broker closes are dropped
+func (b *memoryBroker) PublishDelayed(ctx context.Context, job Job, delay time.Duration) error {
+	select {
+	case <-b.done:
+		return ErrBrokerClosed
+	default:
+	}
+
+	time.AfterFunc(delay, func() {
+		if err := b.enqueue(context.Background(), envelope{job: job}); err != nil {
+			slog.Warn("Dropped delayed job", "config_id", job.ConfigId, "run_id", job.RunId, "error", err)
+		}
+	})
+	return nil
+}
+
+// enqueue adds a job to the back of the queue
+func (b *memoryBroker) enqueue(ctx context.Context, e envelope) error {
+	select {
+	case <-b.done:
+		return ErrBrokerClosed
+	default:
+	}
+
+	select {
+	case b.queue <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return ErrBrokerClosed
+	}
+}
+
+// Consume delivers enqueued jobs until ctx is cancelled or the broker is
+// closed
+func (b *memoryBroker) Consume(ctx context.Context) (<-chan Delivery, error) {
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			var e envelope
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.done:
+				return
+			case e = <-b.queue:
+			}
+
+			select {
+			case deliveries <- b.delivery(e):
+			case <-ctx.Done():
+				b.requeue(e)
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// delivery wraps a queued job, a requeued job goes to the back of the queue
+// and a rejected one to the dead letters
+func (b *memoryBroker) delivery(e envelope) Delivery {
+	return Delivery{
+		Job:         e.job,
+		Redelivered: e.redelivered,
+		Ack:         func() error { return nil },
+		Nack: func(requeue bool) error {
+			if requeue {
+				b.requeue(envelope{job: e.job, redelivered: true})
+				return nil
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.dead = append(b.dead, e.job)
+			return nil
+		},
+	}
+}
+
+// requeue puts a job back without blocking the caller, which may be the
+// worker the queue is waiting on
+func (b *memoryBroker) requeue(e envelope) {
+	go func() {
+		if err := b.enqueue(context.Background(), e); err != nil {
+			slog.Warn("Dropped requeued job", "config_id", e.job.ConfigId, "error", err)
+		}
+	}()
+}
+
+// DeadLetters returns the jobs that were rejected without requeueing
+func (b *memoryBroker) DeadLetters() []Job {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.dead)
+}
+
+// Close stops every consumer, queued jobs are dropped
+func (b *memoryBroker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
@@ -0,0 +1,396 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	rabbitmq "github.com/wagslane/go-rabbitmq"
+)
+
+// RabbitMQOptions configures the rabbitmq broker
+type RabbitMQOptions struct {
+	// URL is the amqp:// or amqps:// address of the broker, credentials in
+	// the URL are used unless Username is set
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Vhost overrides the virtual host of the URL
+	Vhost string     `yaml:"vhost"`
+	TLS   TLSOptions `yaml:"tls"`
+	// Heartbeat is the interval of heartbeats negotiated with the broker,
+	// defaults to 10 seconds
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// ReconnectInterval is the wait between attempts to reach the broker, on
+	// start and after the connection is lost
+	ReconnectInterval time.Duration `yaml:"reconnect_interval"`
+	// Exchange is the direct exchange jobs are published to
+	Exchange string `yaml:"exchange"`
+	// Queue is the queue jobs are routed to, it is used as the routing key
+	Queue string `yaml:"queue"`
+	// DeadLetterExchange receives rejected jobs, which it routes to the
+	// queue named after Queue with a .dead suffix. Empty drops rejected jobs.
+	DeadLetterExchange string `yaml:"dead_letter_exchange"`
+	// Durable declares exchanges and queues that survive a broker restart and
+	// publishes persistent messages
+	Durable bool `yaml:"durable"`
+	// Prefetch is the number of unacknowledged jobs delivered at once
+	Prefetch int `yaml:"prefetch"`
+}
+
+// TLSOptions configures TLS for amqps:// connections
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the authorities trusted to sign the broker
+	// certificate, defaults to the system pool
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are a PEM client certificate and key
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the host name the broker certificate is checked
+	// against
+	ServerName string `yaml:"server_name"`
+}
+
+// enabled reports whether any TLS option is set
+func (o TLSOptions) enabled() bool {
+	return o != TLSOptions{}
+}
+
+// config returns the TLS client config described by the options
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{ServerName: o.ServerName, MinVersion: tls.VersionTLS12}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls ca file %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("tls cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// amqpConfig returns the connection settings of the options
+func (o RabbitMQOptions) amqpConfig() (amqp.Config, error) {
+	uri, err := url.Parse(o.URL)
+	if err != nil {
+		return amqp.Config{}, fmt.Errorf("invalid rabbitmq url: %w", err)
+	}
+
+	config := amqp.Config{
+		Vhost:     o.Vhost,
+		Heartbeat: o.Heartbeat,
+		Locale:    "en_US",
+		Properties: amqp.Table{
+			"connection_name": "mdf",
+		},
+	}
+	if config.Heartbeat == 0 {
+		config.Heartbeat = 10 * time.Second
+	}
+	if o.Username != "" {
+		config.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: o.Username, Password: o.Password}}
+	}
+
+	if o.TLS.enabled() {
+		if uri.Scheme != "amqps" {
+			return amqp.Config{}, fmt.Errorf("rabbitmq tls options require an amqps:// url, got %s://", uri.Scheme)
+		}
+		config.TLSClientConfig, err = o.TLS.config()
+		if err != nil {
+			return amqp.Config{}, err
+		}
+	}
+
+	return config, nil
+}
+
+// deadLetterQueue returns the name of the queue holding rejected jobs
+func (o RabbitMQOptions) deadLetterQueue() string {
+	return o.Queue + ".dead"
+}
+
+// retryQueue returns the name of the queue holding delayed jobs. It has no
+// consumers, jobs expire from it back to the job queue.
+func (o RabbitMQOptions) retryQueue() string {
+	return o.Queue + ".retry"
+}
+
+// rmqBroker is a broker backed by RabbitMQ
+type rmqBroker struct {
+	Client    *rabbitmq.Conn
+	publisher *rabbitmq.Publisher
+	opts      RabbitMQOptions
+
+	mu        sync.Mutex
+	consumers []*rabbitmq.Consumer
+}
+
+// NewRabbitMQBroker connects to a RabbitMQ server and declares the exchanges
+// and queues jobs flow through. While the server cannot be reached it retries
+// every ReconnectInterval until ctx is cancelled; once connected, a lost
+// connection is recovered in the background.
+func NewRabbitMQBroker(ctx context.Context, opts RabbitMQOptions) (*rmqBroker, error) {
+	config, err := opts.amqpConfig()
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = 5 * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		broker, err := connect(opts, config)
+		if err == nil {
+			return broker, nil
+		}
+
+		slog.Warn("Failed to connect to rabbitmq, retrying",
+			"attempt", attempt,
+			"retry_in", opts.ReconnectInterval.String(),
+			"error", err)
+		select {
+		case <-time.After(opts.ReconnectInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting to rabbitmq: %w", err)
+		}
+	}
+}
+
+// connect declares the topology and opens the connection and publisher of
+// the broker
+func connect(opts RabbitMQOptions, config amqp.Config) (*rmqBroker, error) {
+	if err := declare(opts, config); err != nil {
+		return nil, err
+	}
+
+	conn, err := rabbitmq.NewConn(opts.URL,
+		rabbitmq.WithConnectionOptionsConfig(rabbitmq.Config(config)),
+		rabbitmq.WithConnectionOptionsReconnectInterval(opts.ReconnectInterval),
+		rabbitmq.WithConnectionOptionsLogging,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+
+	publisher, err := rabbitmq.NewPublisher(conn,
+		rabbitmq.WithPublisherOptionsConfirm,
+		rabbitmq.WithPublisherOptionsExchangeName(opts.Exchange),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create rabbitmq publisher: %w", err)
+	}
+
+	// Mandatory messages come back when no queue is bound to the exchange
+	publisher.NotifyReturn(func(r rabbitmq.Return) {
+		slog.Error("Job returned by broker, no queue is bound",
+			"exchange", r.Exchange,
+			"routing_key", r.RoutingKey,
+			"run_id", r.MessageId,
+			"reason", r.ReplyText)
+	})
+
+	return &rmqBroker{Client: conn, publisher: publisher, opts: opts}, nil
+}
+
+// declare creates the exchanges and queues of the broker, and binds them
+func declare(opts RabbitMQOptions, config amqp.Config) error {
+	conn, err := amqp.DialConfig(opts.URL, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open rabbitmq channel: %w", err)
+	}
+	defer ch.Close()
+
+	args := amqp.Table{}
+	if opts.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = opts.DeadLetterExchange
+		args["x-dead-letter-routing-key"] = opts.deadLetterQueue()
+		if err := bind(ch, opts.DeadLetterExchange, opts.deadLetterQueue(), opts.Durable, nil); err != nil {
+			return err
+		}
+	}
+	retryArgs := amqp.Table{
+		"x-dead-letter-exchange":    opts.Exchange,
+		"x-dead-letter-routing-key": opts.Queue,
+	}
+	if err := bind(ch, opts.Exchange, opts.retryQueue(), opts.Durable, retryArgs); err != nil {
+		return err
+	}
+	return bind(ch, opts.Exchange, opts.Queue, opts.Durable, args)
+}
+
+// bind declares a direct exchange and a queue bound to it with the queue name
+// as routing key
+func bind(ch *amqp.Channel, exchange, queue string, durable bool, args amqp.Table) error {
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeDirect, durable, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+	}
+	if _, err := ch.QueueDeclare(queue, durable, false, false, false, args); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+	if err := ch.QueueBind(queue, queue, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s to exchange %s: %w", queue, exchange, err)
+	}
+	return nil
+}
+
+// Publish enqueues a job as a JSON message, persistent when the broker is
+// durable, and waits for the broker to confirm it
+func (b *rmqBroker) Publish(ctx context.Context, job Job) error {
+	return b.publish(ctx, job, b.opts.Queue)
+}
+
+// PublishDelayed parks a job in the retry queue until delay has passed. Jobs
+// expire in order, so a job may wait for a longer delay queued before it.
+func (b *rmqBroker) PublishDelayed(ctx context.Context, job Job, delay time.Duration) error {
+	expiration := strconv.FormatInt(max(delay.Milliseconds(), 0), 10)
+	return b.publish(ctx, job, b.opts.retryQueue(), rabbitmq.WithPublishOptionsExpiration(expiration))
+}
+
+// publish sends a job to a queue of the exchange and waits for the broker to
+// confirm it
+func (b *rmqBroker) publish(ctx context.Context, job Job, routingKey string, extra ...func(*rabbitmq.PublishOptions)) error {
+	data, err := EncodeJob(job)
+	if err != nil {
+		return err
+	}
+
+	options := []func(*rabbitmq.PublishOptions){
+		rabbitmq.WithPublishOptionsExchange(b.opts.Exchange),
+		rabbitmq.WithPublishOptionsContentType("application/json"),
+		rabbitmq.WithPublishOptionsMandatory,
+		rabbitmq.WithPublishOptionsMessageID(job.RunId),
+		rabbitmq.WithPublishOptionsType(fmt.Sprintf("mdf.job.v%d", JOB_MESSAGE_VERSION)),
+	}
+	if b.opts.Durable {
+		options = append(options, rabbitmq.WithPublishOptionsPersistentDelivery)
+	}
+	options = append(options, extra...)
+
+	confirms, err := b.publisher.PublishWithDeferredConfirmWithContext(ctx, data, []string{routingKey}, options...)
+	if err != nil {
+		return fmt.Errorf("failed to publish job: %w", err)
+	}
+
+	for _, confirm := range confirms {
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to confirm job: %w", err)
+		}
+		if !acked {
+			return fmt.Errorf("broker rejected job %s", job.RunId)
+		}
+	}
+	return nil
+}
+
+// Consume delivers jobs from the queue until ctx is cancelled. At most
+// Prefetch jobs are unacknowledged at once, messages that are not valid jobs
+// are rejected without requeueing. The consumer stays open until the broker
+// is closed, so jobs delivered before ctx was cancelled can still be settled.
+func (b *rmqBroker) Consume(ctx context.Context) (<-chan Delivery, error) {
+	consumer, err := rabbitmq.NewConsumer(b.Client, b.opts.Queue,
+		rabbitmq.WithConsumerOptionsQueueNoDeclare,
+		rabbitmq.WithConsumerOptionsQOSPrefetch(max(b.opts.Prefetch, 1)),
+		rabbitmq.WithConsumerOptionsLogging,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rabbitmq consumer: %w", err)
+	}
+	b.mu.Lock()
+	b.consumers = append(b.consumers, consumer)
+	b.mu.Unlock()
+
+	// The handler reads closed under mu, so deliveries is never sent to after
+	// it is closed
+	var mu sync.RWMutex
+	closed := false
+	deliveries := make(chan Delivery)
+	handler := func(d rabbitmq.Delivery) rabbitmq.Action {
+		job, err := DecodeJob(d.Body)
+		if err != nil {
+			slog.Error("Rejecting invalid job message", "message_id", d.MessageId, "error", err)
+			return rabbitmq.NackDiscard
+		}
+
+		mu.RLock()
+		defer mu.RUnlock()
+		if closed {
+			return rabbitmq.NackRequeue
+		}
+
+		delivery := Delivery{
+			Job:         job,
+			Redelivered: d.Redelivered,
+			Ack:         func() error { return d.Ack(false) },
+			Nack:        func(requeue bool) error { return d.Nack(false, requeue) },
+		}
+		select {
+		case deliveries <- delivery:
+			// The worker settles the message
+			return rabbitmq.Manual
+		case <-ctx.Done():
+			return rabbitmq.NackRequeue
+		}
+	}
+
+	go func() {
+		if err := consumer.Run(handler); err != nil {
+			slog.Error("Rabbitmq consumer stopped", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(deliveries)
+	}()
+
+	return deliveries, nil
+}
+
+// Close closes the consumers, the publisher and the connection. Unsettled
+// jobs are redelivered by the server.
+func (b *rmqBroker) Close() error {
+	b.mu.Lock()
+	for _, consumer := range b.consumers {
+		consumer.Close()
+	}
+	b.consumers = nil
+	b.mu.Unlock()
+
+	b.publisher.Close()
+	return b.Client.Close()
+}
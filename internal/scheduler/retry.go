@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/executor"
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// Defaults of a retry policy, see parser.RetryConfig
+const (
+	defaultRetryDelay    = 30 * time.Second
+	defaultRetryMaxDelay = 10 * time.Minute
+)
+
+// maxAttempts returns the number of times a job runs before it is dead
+// lettered
+func maxAttempts(policy parser.RetryConfig) int {
+	return max(policy.MaxAttempts, 1)
+}
+
+// retryable reports whether a policy retries an error. Only classified
+// errors are retried, so a cancelled job is never retried.
+func retryable(policy parser.RetryConfig, err error) bool {
+	class := executor.Class(err)
+	if class == "" {
+		return false
+	}
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = []string{parser.CONNECTOR_ERROR}
+	}
+	return slices.Contains(retryOn, class)
+}
+
+// retryDelay returns the wait before retrying a job that failed the given
+// attempt, counting from 1. Exponential backoff doubles the delay after every
+// attempt up to the max delay, jitter waits a random time between half and
+// all of the exponential delay.
+func retryDelay(policy parser.RetryConfig, attempt int) time.Duration {
+	delay := cmp.Or(policy.Delay, defaultRetryDelay)
+	maxDelay := max(cmp.Or(policy.MaxDelay, defaultRetryMaxDelay), delay)
+
+	if policy.Backoff == parser.BACKOFF_FIXED {
+		return delay
+	}
+
+	for range attempt - 1 {
+		if delay >= maxDelay/2 {
+			delay = maxDelay
+			break
+		}
+		delay *= 2
+	}
+
+	if policy.Backoff == parser.BACKOFF_JITTER {
+		half := delay / 2
+		return half + rand.N(delay-half+1)
+	}
+	return delay
+}
@@ -1,12 +1,401 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/executor"
+	"github.com/andrew-a-hale/mdf/internal/parser"
 )
 
-func TestNewCronTriggerer(t *testing.T) {
-	v := New()
-	if v == nil {
-		t.Fatal("New() returned nil")
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker(2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, id := range []string{"a", "b"} {
+		if err := broker.Publish(ctx, Job{ConfigId: id}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	deliveries, err := broker.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	// A requeued job is delivered again after the queued ones
+	first := <-deliveries
+	first.Nack(true)
+	second := <-deliveries
+	second.Ack()
+	third := <-deliveries
+	third.Ack()
+	if first.Job.ConfigId != "a" || second.Job.ConfigId != "b" || third.Job.ConfigId != "a" {
+		t.Errorf("Delivered %s, %s, %s, want a, b, a", first.Job.ConfigId, second.Job.ConfigId, third.Job.ConfigId)
+	}
+
+	// Closing the broker ends consumers and rejects new jobs
+	broker.Close()
+	if _, ok := <-deliveries; ok {
+		t.Error("Deliveries should be closed with the broker")
+	}
+	if err := broker.Publish(ctx, Job{ConfigId: "c"}); !errors.Is(err, ErrBrokerClosed) {
+		t.Errorf("Publish() after Close() error = %v, want %v", err, ErrBrokerClosed)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	configs := parser.Configs{{Id: "ok"}, {Id: "failing"}}
+	broker := NewMemoryBroker(10)
+	defer broker.Close()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	outcomes := make(map[string]error)
+	s := New(broker, configs.Lookup, Options{
+		Workers: 2,
+		Run: func(ctx context.Context, config parser.Config) error {
+			if config.Id == "failing" {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		OnComplete: func(job Job, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[job.ConfigId] = err
+			wg.Done()
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ctx := context.Background()
+	wg.Add(3)
+	for _, id := range []string{"ok", "unknown", "failing"} {
+		if err := broker.Publish(ctx, Job{ConfigId: id}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	wg.Wait()
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	// Unknown configs are dead lettered without running and reported as
+	// failed
+	if len(outcomes) != 3 || outcomes["ok"] != nil || outcomes["failing"] == nil {
+		t.Errorf("Outcomes = %v, want ok, failing and unknown", outcomes)
+	}
+	if !errors.Is(outcomes["unknown"], ErrUnknownConfig) {
+		t.Errorf("Outcome of unknown = %v, want %v", outcomes["unknown"], ErrUnknownConfig)
+	}
+	var dead []string
+	for _, job := range broker.DeadLetters() {
+		dead = append(dead, job.ConfigId)
+	}
+	slices.Sort(dead)
+	if !slices.Equal(dead, []string{"failing", "unknown"}) {
+		t.Errorf("Dead letters = %v, want failing and unknown", dead)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	configs := parser.Configs{{Id: "flaky"}, {Id: "failing"}}
+	broker := NewMemoryBroker(10)
+	defer broker.Close()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	runs := make(map[string]int)
+	outcomes := make(map[string]error)
+	s := New(broker, configs.Lookup, Options{
+		FailurePolicy: REQUEUE,
+		Run: func(ctx context.Context, config parser.Config) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[config.Id]++
+			if config.Id == "failing" || runs[config.Id] == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		OnComplete: func(job Job, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[job.ConfigId] = err
+			wg.Done()
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	wg.Add(2)
+	for _, id := range []string{"flaky", "failing"} {
+		broker.Publish(context.Background(), NewJob(id, time.Now(), MANUAL))
+	}
+	wg.Wait()
+	s.Stop(context.Background())
+
+	// Failed jobs are requeued once and only complete with their final outcome
+	if runs["flaky"] != 2 || runs["failing"] != 2 {
+		t.Errorf("Runs = %v, want 2 each", runs)
+	}
+	if outcomes["flaky"] != nil || outcomes["failing"] == nil {
+		t.Errorf("Outcomes = %v, want flaky to succeed and failing to fail", outcomes)
+	}
+	if dead := broker.DeadLetters(); len(dead) != 1 || dead[0].ConfigId != "failing" {
+		t.Errorf("Dead letters = %v, want failing", dead)
+	}
+}
+
+func TestJobMessage(t *testing.T) {
+	job := NewJob("sales.orders", time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), CRON)
+	if job.RunId == "" || job.Attempt != 1 {
+		t.Fatalf("NewJob() = %+v, want a run id and attempt 1", job)
+	}
+
+	data, err := EncodeJob(job)
+	if err != nil {
+		t.Fatalf("EncodeJob() error = %v", err)
+	}
+	decoded, err := DecodeJob(data)
+	if err != nil {
+		t.Fatalf("DecodeJob() error = %v", err)
+	}
+	if decoded != job {
+		t.Errorf("DecodeJob() = %+v, want %+v", decoded, job)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{name: "newer version", message: `{"version": 2, "config_id": "orders"}`},
+		{name: "missing version", message: `{"config_id": "orders"}`},
+		{name: "missing config", message: `{"version": 1}`},
+		{name: "invalid json", message: `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeJob([]byte(tt.message)); err == nil {
+				t.Errorf("DecodeJob(%s) should return error", tt.message)
+			}
+		})
+	}
+}
+
+func TestRabbitMQConfig(t *testing.T) {
+	config, err := RabbitMQOptions{
+		URL:      "amqp://localhost:5672/",
+		Username: "mdf",
+		Password: "secret",
+		Vhost:    "jobs",
+	}.amqpConfig()
+	if err != nil {
+		t.Fatalf("amqpConfig() error = %v", err)
+	}
+	if config.Vhost != "jobs" || config.Heartbeat != 10*time.Second {
+		t.Errorf("amqpConfig() vhost = %q, heartbeat = %v, want jobs and 10s", config.Vhost, config.Heartbeat)
+	}
+	if len(config.SASL) != 1 || config.SASL[0].Response() != "\x00mdf\x00secret" {
+		t.Errorf("amqpConfig() should authenticate with the username and password")
+	}
+
+	dir, err := os.MkdirTemp("", "mdf-tls-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		tls  TLSOptions
+	}{
+		{name: "tls without amqps", url: "amqp://localhost/", tls: TLSOptions{ServerName: "rabbit"}},
+		{name: "missing ca file", url: "amqps://localhost/", tls: TLSOptions{CAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "invalid ca file", url: "amqps://localhost/", tls: TLSOptions{CAFile: invalid}},
+		{name: "cert without key", url: "amqps://localhost/", tls: TLSOptions{CertFile: invalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (RabbitMQOptions{URL: tt.url, TLS: tt.tls}).amqpConfig(); err == nil {
+				t.Errorf("amqpConfig() should return error")
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		finish  bool
+		wantErr error
+	}{
+		{name: "drained", finish: true},
+		{name: "cancelled at deadline", finish: false, wantErr: ErrNotDrained},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := parser.Configs{{Id: "slow"}}
+			broker := NewMemoryBroker(10)
+			defer broker.Close()
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			completed := false
+			s := New(broker, configs.Lookup, Options{
+				Run: func(ctx context.Context, config parser.Config) error {
+					close(started)
+					select {
+					case <-release:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				},
+				OnComplete: func(job Job, err error) { completed = true },
+			})
+			if err := s.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			broker.Publish(context.Background(), NewJob("slow", time.Now(), MANUAL))
+			<-started
+
+			if tt.finish {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					close(release)
+				}()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := s.Stop(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Stop() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// A cancelled job goes back to the queue instead of completing
+			if completed != tt.finish {
+				t.Errorf("Completed = %v, want %v", completed, tt.finish)
+			}
+			if len(broker.DeadLetters()) != 0 {
+				t.Errorf("Dead letters = %v, want none", broker.DeadLetters())
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   parser.RetryConfig
+		attempt  int
+		min, max time.Duration
+	}{
+		{name: "default", attempt: 1, min: 30 * time.Second, max: 30 * time.Second},
+		{name: "exponential", policy: parser.RetryConfig{Delay: time.Second}, attempt: 4, min: 8 * time.Second, max: 8 * time.Second},
+		{name: "capped", policy: parser.RetryConfig{Delay: time.Second, MaxDelay: 5 * time.Second}, attempt: 10, min: 5 * time.Second, max: 5 * time.Second},
+		{name: "fixed", policy: parser.RetryConfig{Backoff: parser.BACKOFF_FIXED, Delay: time.Second}, attempt: 4, min: time.Second, max: time.Second},
+		{name: "jitter", policy: parser.RetryConfig{Backoff: parser.BACKOFF_JITTER, Delay: time.Second}, attempt: 2, min: time.Second, max: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := retryDelay(tt.policy, tt.attempt); delay < tt.min || delay > tt.max {
+				t.Errorf("retryDelay() = %v, want between %v and %v", delay, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	retry := parser.RetryConfig{MaxAttempts: 3, Delay: time.Millisecond}
+	configs := parser.Configs{
+		{Id: "flaky", DataSource: parser.DataSource{Retry: retry}},
+		{Id: "down", DataSource: parser.DataSource{Retry: retry}},
+		{Id: "invalid", DataSource: parser.DataSource{Retry: retry}},
+	}
+	broker := NewMemoryBroker(10)
+	defer broker.Close()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	runs := make(map[string]int)
+	outcomes := make(map[string]error)
+	s := New(broker, configs.Lookup, Options{
+		Run: func(ctx context.Context, config parser.Config) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[config.Id]++
+			switch {
+			case config.Id == "invalid":
+				return &executor.Error{Class: parser.VALIDATION_ERROR, Err: errors.New("null id")}
+			case config.Id == "down" || runs[config.Id] < 3:
+				return &executor.Error{Class: parser.CONNECTOR_ERROR, Err: errors.New("connection refused")}
+			}
+			return nil
+		},
+		OnComplete: func(job Job, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[job.ConfigId] = err
+			wg.Done()
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	wg.Add(3)
+	for _, id := range []string{"flaky", "down", "invalid"} {
+		broker.Publish(context.Background(), NewJob(id, time.Now(), MANUAL))
+	}
+	wg.Wait()
+	s.Stop(context.Background())
+
+	// Connector errors are retried up to max_attempts, validation errors are
+	// not retried by default
+	want := map[string]int{"flaky": 3, "down": 3, "invalid": 1}
+	for id, n := range want {
+		if runs[id] != n {
+			t.Errorf("Runs of %s = %d, want %d", id, runs[id], n)
+		}
+	}
+	if outcomes["flaky"] != nil || outcomes["down"] == nil || outcomes["invalid"] == nil {
+		t.Errorf("Outcomes = %v, want flaky to succeed", outcomes)
+	}
+
+	var dead []string
+	for _, job := range broker.DeadLetters() {
+		dead = append(dead, fmt.Sprintf("%s/%d", job.ConfigId, job.Attempt))
+	}
+	slices.Sort(dead)
+	if !slices.Equal(dead, []string{"down/3", "invalid/1"}) {
+		t.Errorf("Dead letters = %v, want down/3 and invalid/1", dead)
+	}
+}
+
+func TestDeadLetterListLimit(t *testing.T) {
+	// Nothing is taken from the queue without a positive limit
+	q := &deadLetterQueue{}
+	for _, limit := range []int{0, -1} {
+		letters, err := q.List(limit)
+		if err != nil || len(letters) != 0 {
+			t.Errorf("List(%d) = %v, %v, want nothing", limit, letters, err)
+		}
 	}
 }
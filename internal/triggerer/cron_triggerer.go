@@ -1,31 +1,63 @@
 package triggerer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
 	"github.com/robfig/cron/v3"
 )
 
+// postAttempts and postBackoff bound the retries of posting a job, the
+// backoff doubles after every failed attempt
+var (
+	postAttempts = 5
+	postBackoff  = time.Second
+)
+
 // CronTriggerer handles the triggering of data ingestion jobs
 type CronTriggerer struct {
+	mu      sync.Mutex
 	configs *parser.Configs
 	cron    *cron.Cron
+	entries map[string]entry
+	deps    *dependencies
+	broker  scheduler.Broker
+	// stopping is closed by Stop to abandon jobs waiting to be posted
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
-// New creates a new triggerer instance
-func New(configs *parser.Configs) *CronTriggerer {
+// entry is a scheduled config and the fingerprint it was scheduled with
+type entry struct {
+	id          cron.EntryID
+	fingerprint string
+}
+
+// New creates a new triggerer instance that posts jobs to a broker
+func New(configs *parser.Configs, broker scheduler.Broker) *CronTriggerer {
 	return &CronTriggerer{
-		configs: configs,
-		cron:    cron.New(),
+		configs:  configs,
+		cron:     cron.New(),
+		entries:  make(map[string]entry),
+		deps:     newDependencies(configs),
+		broker:   broker,
+		stopping: make(chan struct{}),
 	}
 }
 
 // Start starts the triggerer
 func (c *CronTriggerer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	for _, conf := range *c.configs {
 		if conf.DataSource.Trigger.Cron == "" {
 			continue
@@ -45,12 +77,168 @@ func (c *CronTriggerer) Start() error {
 	return nil
 }
 
-// Stop stops the triggerer
-func (c *CronTriggerer) Stop() {
-	c.cron.Stop()
+// Stop stops firing triggers and waits until jobs being posted are posted or
+// abandoned, or ctx is done
+func (c *CronTriggerer) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stopping) })
+	select {
+	case <-c.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to stop triggerer: %w", ctx.Err())
+	}
+}
+
+// Configs returns the configs the triggerer is currently running
+func (c *CronTriggerer) Configs() *parser.Configs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.configs
+}
+
+// Reload replaces the running configs, adding, replacing and removing cron
+// entries for configs that were added, changed or removed. Every schedule is
+// prepared before any entry is touched, so a failed reload leaves the running
+// configs in place.
+func (c *CronTriggerer) Reload(configs *parser.Configs) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := make(map[string]parser.Config)
+	for _, conf := range *configs {
+		if conf.DataSource.Trigger.Cron != "" {
+			next[conf.Id] = conf
+		}
+	}
+
+	// Work out which entries change
+	var added, changed, removed []string
+	for id, e := range c.entries {
+		conf, ok := next[id]
+		switch {
+		case !ok:
+			removed = append(removed, id)
+		case conf.Fingerprint() != e.fingerprint:
+			changed = append(changed, id)
+		}
+	}
+	for id := range next {
+		if _, ok := c.entries[id]; !ok {
+			added = append(added, id)
+		}
+	}
+
+	// Prepare every new schedule before applying anything
+	type prepared struct {
+		conf     parser.Config
+		schedule cron.Schedule
+		job      cron.Job
+	}
+	var jobs []prepared
+	var errs []error
+	for _, id := range append(slices.Clone(added), changed...) {
+		schedule, job, err := c.prepare(next[id])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config %s: %w", id, err))
+			continue
+		}
+		jobs = append(jobs, prepared{next[id], schedule, job})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to reload triggerer: %w", err)
+	}
+
+	// Apply the changes
+	for _, id := range append(removed, changed...) {
+		c.cron.Remove(c.entries[id].id)
+		delete(c.entries, id)
+	}
+	for _, p := range jobs {
+		c.entries[p.conf.Id] = entry{
+			id:          c.cron.Schedule(p.schedule, p.job),
+			fingerprint: p.conf.Fingerprint(),
+		}
+	}
+	c.configs = configs
+	c.deps.update(configs)
+
+	level := slog.LevelInfo
+	if len(added)+len(changed)+len(removed) == 0 {
+		level = slog.LevelDebug
+	}
+	slices.Sort(added)
+	slices.Sort(changed)
+	slices.Sort(removed)
+	slog.Log(context.Background(), level, "Triggerer reloaded",
+		"added", added,
+		"changed", changed,
+		"removed", removed,
+		"jobs_count", len(c.entries))
+
+	return nil
+}
+
+// Complete records the outcome of a job and triggers the downstream jobs whose
+// upstreams have all succeeded for the same logical interval, which runs
+// between fire times of the least frequent schedule a downstream job depends
+// on. Downstream jobs of a failed job are skipped.
+func (c *CronTriggerer) Complete(job scheduler.Job, err error) error {
+	c.mu.Lock()
+	ready, skipped := c.deps.complete(job.ConfigId, job.LogicalTime, err)
+	c.mu.Unlock()
+
+	for _, r := range skipped {
+		slog.Warn("Skipping job, upstream failed",
+			"config_id", r.configId,
+			"upstream", job.ConfigId,
+			"logical_time", r.logicalTime)
+	}
+
+	var errs []error
+	for _, r := range ready {
+		downstream := scheduler.NewJob(r.configId, r.logicalTime, scheduler.DEPENDENCY)
+		if err := c.post(context.Background(), downstream); err != nil {
+			errs = append(errs, fmt.Errorf("failed to post job %s: %w", r.configId, err))
+			continue
+		}
+		slog.Info("Upstreams succeeded, job posted to queue",
+			"config_id", r.configId,
+			"run_id", downstream.RunId,
+			"logical_time", r.logicalTime)
+	}
+	return errors.Join(errs...)
+}
+
+// Post enqueues a job on the broker
+func (c *CronTriggerer) Post(job scheduler.Job) error {
+	return c.broker.Publish(context.Background(), job)
+}
+
+// post enqueues a job, retrying with exponential backoff while the broker
+// reports failures, until ctx is done
+func (c *CronTriggerer) post(ctx context.Context, job scheduler.Job) error {
+	var err error
+	for attempt := range postAttempts {
+		if attempt > 0 {
+			backoff := postBackoff << (attempt - 1)
+			slog.Warn("Failed to post job, retrying",
+				"config_id", job.ConfigId,
+				"run_id", job.RunId,
+				"error", err,
+				"backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("gave up posting job: %w", err)
+			}
+		}
+		if err = c.broker.Publish(ctx, job); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to post job after %d attempts: %w", postAttempts, err)
 }
 
-func (c *CronTriggerer) Post(configId string) error                   { return nil }
 func (c *CronTriggerer) RegisterQueue(config map[string]string) error { return nil }
 func (c *CronTriggerer) DeregisterQueue(queueId string) error         { return nil }
 
@@ -63,7 +251,42 @@ func (c *CronTriggerer) trigger(conf parser.Config) error {
 		"cron", conf.DataSource.Trigger.Cron,
 		"random_offset", conf.DataSource.Trigger.RandomOffset)
 
+	schedule, job, err := c.prepare(conf)
+	if err != nil {
+		return err
+	}
+
+	// Add the job to the cron scheduler
+	c.entries[conf.Id] = entry{
+		id:          c.cron.Schedule(schedule, job),
+		fingerprint: conf.Fingerprint(),
+	}
+
+	return nil
+}
+
+// prepare parses the schedule of a data source and builds its cron job
+func (c *CronTriggerer) prepare(conf parser.Config) (cron.Schedule, cron.Job, error) {
+	schedule, err := cron.ParseStandard(conf.DataSource.Trigger.Cron)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression '%s': %w", conf.DataSource.Trigger.Cron, err)
+	}
+
 	jobFunc := func() {
+		// Triggers fired while stopping are abandoned
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-c.stopping:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// The fire time of the schedule marks the logical interval
+		job := scheduler.NewJob(conf.Id, previous(schedule, time.Now()), scheduler.CRON)
+
 		// Apply random offset if configured
 		if conf.DataSource.Trigger.RandomOffset {
 			offset := time.Duration(rand.Intn(60)) * time.Second
@@ -71,27 +294,28 @@ func (c *CronTriggerer) trigger(conf parser.Config) error {
 				"domain", conf.DataSource.Domain,
 				"name", conf.DataSource.Name,
 				"offset_seconds", offset.Seconds())
-			time.Sleep(offset)
+			select {
+			case <-time.After(offset):
+			case <-ctx.Done():
+				slog.Info("Triggerer stopping, job not posted", "config_id", conf.Id, "run_id", job.RunId)
+				return
+			}
 		}
 
-		err := c.Post(conf.Id)
+		err := c.post(ctx, job)
 		if err != nil {
 			slog.Error("Job failed posted to queue",
 				"domain", conf.DataSource.Domain,
 				"name", conf.DataSource.Name,
+				"run_id", job.RunId,
 				"error", err)
 		} else {
 			slog.Info("Job posted to queue",
 				"domain", conf.DataSource.Domain,
-				"name", conf.DataSource.Name)
+				"name", conf.DataSource.Name,
+				"run_id", job.RunId)
 		}
 	}
 
-	// Add the job to the cron scheduler
-	_, err := c.cron.AddFunc(conf.DataSource.Trigger.Cron, jobFunc)
-	if err != nil {
-		return fmt.Errorf("invalid cron expression '%s': %w", conf.DataSource.Trigger.Cron, err)
-	}
-
-	return nil
+	return schedule, cron.FuncJob(jobFunc), nil
 }
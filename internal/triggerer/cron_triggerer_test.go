@@ -1,9 +1,13 @@
 package triggerer
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
 )
 
 func TestNewCronTriggerer(t *testing.T) {
@@ -18,8 +22,145 @@ func TestNewCronTriggerer(t *testing.T) {
 			},
 		},
 	}
-	v := New(&configs)
+	v := New(&configs, scheduler.NewMemoryBroker(1))
 	if v == nil {
 		t.Fatal("New() returned nil")
 	}
 }
+
+func TestReload(t *testing.T) {
+	config := func(id, cron string) parser.Config {
+		return parser.Config{
+			Id:         id,
+			DataSource: parser.DataSource{Trigger: parser.TriggerConfig{Cron: cron}},
+		}
+	}
+
+	configs := parser.Configs{config("a", "* * * * *"), config("b", "0 * * * *"), config("c", "")}
+	v := New(&configs, scheduler.NewMemoryBroker(1))
+	if err := v.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer v.Stop(context.Background())
+
+	if len(v.cron.Entries()) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(v.cron.Entries()))
+	}
+	unchanged := v.entries["a"].id
+	changed := v.entries["b"].id
+
+	// Keep a, change b and add d
+	next := parser.Configs{config("a", "* * * * *"), config("b", "30 * * * *"), config("d", "0 0 * * *")}
+	if err := v.Reload(&next); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	if len(v.cron.Entries()) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(v.cron.Entries()))
+	}
+	if v.entries["a"].id != unchanged {
+		t.Error("Unchanged config should keep its entry")
+	}
+	if v.entries["b"].id == changed {
+		t.Error("Changed config should be rescheduled")
+	}
+	if v.Configs() != &next {
+		t.Error("Configs() should return the reloaded configs")
+	}
+
+	removed := parser.Configs{config("a", "* * * * *")}
+	if err := v.Reload(&removed); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(v.cron.Entries()) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(v.cron.Entries()))
+	}
+
+	// A broken reload keeps the running configs
+	broken := parser.Configs{config("a", "* * * * *"), config("e", "not a cron")}
+	if err := v.Reload(&broken); err == nil {
+		t.Fatal("Reload() with invalid cron should return error")
+	}
+	if len(v.cron.Entries()) != 1 || v.Configs() != &removed {
+		t.Error("Failed reload should keep the running configs")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	configs := parser.Configs{
+		parser.Config{Id: "customers", DataSource: parser.DataSource{Trigger: parser.TriggerConfig{Cron: "0 * * * *"}}},
+		parser.Config{Id: "orders", DataSource: parser.DataSource{Trigger: parser.TriggerConfig{DependsOn: []string{"customers"}}}},
+	}
+	broker := scheduler.NewMemoryBroker(1)
+	defer broker.Close()
+	v := New(&configs, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	deliveries, err := broker.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	// A succeeded upstream posts its downstream for the same logical time
+	logicalTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := v.Complete(scheduler.Job{ConfigId: "customers", LogicalTime: logicalTime}, nil); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	select {
+	case d := <-deliveries:
+		if d.Job.ConfigId != "orders" || !d.Job.LogicalTime.Equal(logicalTime) {
+			t.Errorf("Posted job = %+v, want orders at %s", d.Job, logicalTime)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expected downstream job to be posted")
+	}
+}
+
+// flakyBroker fails the first publishes
+type flakyBroker struct {
+	scheduler.Broker
+	failures int
+	jobs     []scheduler.Job
+}
+
+func (b *flakyBroker) Publish(ctx context.Context, job scheduler.Job) error {
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("broker unavailable")
+	}
+	b.jobs = append(b.jobs, job)
+	return nil
+}
+
+func TestPostRetry(t *testing.T) {
+	saved := postBackoff
+	postBackoff = time.Millisecond
+	defer func() { postBackoff = saved }()
+
+	configs := parser.Configs{}
+	broker := &flakyBroker{failures: 2}
+	v := New(&configs, broker)
+
+	job := scheduler.NewJob("orders", time.Now(), scheduler.MANUAL)
+	if err := v.post(context.Background(), job); err != nil {
+		t.Fatalf("post() error = %v", err)
+	}
+	if len(broker.jobs) != 1 || broker.jobs[0] != job {
+		t.Errorf("Published %v, want the job once", broker.jobs)
+	}
+
+	broker.failures = postAttempts
+	if err := v.post(context.Background(), job); err == nil {
+		t.Error("post() should fail once every attempt failed")
+	}
+
+	// A cancelled post stops waiting out the backoff
+	postBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	broker.failures = 1
+	if err := v.post(ctx, job); err == nil {
+		t.Error("post() should give up once ctx is done")
+	}
+}
@@ -0,0 +1,283 @@
+package triggerer
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/robfig/cron/v3"
+)
+
+// maxIntervals bounds the logical intervals tracked at once, so intervals
+// whose upstreams never all finish are eventually forgotten
+const maxIntervals = 256
+
+// maxLookback bounds how far back the previous fire time of a schedule is
+// searched for
+const maxLookback = 5 * 366 * 24 * time.Hour
+
+// dependencies tracks job outcomes per downstream job and logical interval and
+// works out which downstream jobs are ready to run or must be skipped
+type dependencies struct {
+	upstreams   map[string][]string
+	downstreams map[string][]string
+	// schedules holds the schedule whose fire times bound the intervals of
+	// each downstream job
+	schedules map[string]cron.Schedule
+	// intervals holds the open intervals of each downstream job by the time
+	// they start
+	intervals map[string]map[time.Time]*interval
+	count     int
+}
+
+// interval holds the outcomes of the upstreams of a downstream job for one
+// logical interval
+type interval struct {
+	// succeeded records finished upstreams, false when they failed or were
+	// skipped
+	succeeded map[string]bool
+	// resolved is set once the downstream job was triggered or skipped
+	resolved bool
+}
+
+// run is a downstream job and the logical interval it runs for
+type run struct {
+	configId    string
+	logicalTime time.Time
+}
+
+// newDependencies builds the dependency graph of a set of configs
+func newDependencies(configs *parser.Configs) *dependencies {
+	d := &dependencies{
+		upstreams:   make(map[string][]string),
+		downstreams: make(map[string][]string),
+		schedules:   make(map[string]cron.Schedule),
+		intervals:   make(map[string]map[time.Time]*interval),
+	}
+	d.update(configs)
+	return d
+}
+
+// update replaces the dependency graph, keeping the outcomes recorded so far
+func (d *dependencies) update(configs *parser.Configs) {
+	clear(d.upstreams)
+	clear(d.downstreams)
+	clear(d.schedules)
+
+	roots := make(map[string]cron.Schedule)
+	for _, conf := range *configs {
+		for _, upstream := range conf.DataSource.Trigger.DependsOn {
+			d.upstreams[conf.Id] = append(d.upstreams[conf.Id], upstream)
+			d.downstreams[upstream] = append(d.downstreams[upstream], conf.Id)
+		}
+		if conf.DataSource.Trigger.Cron == "" {
+			continue
+		}
+		// Configs are validated before they reach the triggerer
+		if schedule, err := cron.ParseStandard(conf.DataSource.Trigger.Cron); err == nil {
+			roots[conf.Id] = schedule
+		}
+	}
+
+	for id := range d.upstreams {
+		if schedule := d.schedule(id, roots); schedule != nil {
+			d.schedules[id] = schedule
+		}
+	}
+}
+
+// schedule returns the schedule of a downstream job, the least frequent
+// schedule of the scheduled jobs it depends on, or nil when none is
+// scheduled. Upstreams on more frequent schedules fall into its intervals, so
+// a daily and an hourly upstream meet once a day.
+func (d *dependencies) schedule(id string, roots map[string]cron.Schedule) cron.Schedule {
+	var ids []string
+	seen := map[string]bool{id: true}
+	pending := []string{id}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for _, upstream := range d.upstreams[current] {
+			if seen[upstream] {
+				continue
+			}
+			seen[upstream] = true
+			pending = append(pending, upstream)
+			if _, ok := roots[upstream]; ok {
+				ids = append(ids, upstream)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Compare the average gap between the next fire times of each schedule
+	slices.Sort(ids)
+	now := time.Now()
+	period := func(schedule cron.Schedule) time.Duration {
+		const fires = 8
+		next := schedule.Next(now)
+		last := next
+		for range fires {
+			last = schedule.Next(last)
+		}
+		return last.Sub(next) / fires
+	}
+	slowest := ids[0]
+	for _, id := range ids[1:] {
+		if period(roots[id]) > period(roots[slowest]) {
+			slowest = id
+		}
+	}
+	return roots[slowest]
+}
+
+// start returns the start of the interval of a downstream job that a logical
+// time falls into, the previous fire time of its schedule
+func (d *dependencies) start(id string, logicalTime time.Time) time.Time {
+	schedule, ok := d.schedules[id]
+	if !ok {
+		return logicalTime
+	}
+	return previous(schedule, logicalTime)
+}
+
+// previous returns the latest fire time of a schedule at or before t, or t
+// when the schedule did not fire within maxLookback
+func previous(schedule cron.Schedule, t time.Time) time.Time {
+	for lookback := time.Minute; lookback <= maxLookback; lookback *= 2 {
+		fire := schedule.Next(t.Add(-lookback))
+		if fire.IsZero() || fire.After(t) {
+			continue
+		}
+		for {
+			next := schedule.Next(fire)
+			if next.IsZero() || next.After(t) {
+				return fire
+			}
+			fire = next
+		}
+	}
+	return t
+}
+
+// complete records the outcome of a job and returns the downstream jobs that
+// are now ready to run and those skipped because an upstream failed
+func (d *dependencies) complete(configId string, logicalTime time.Time, err error) (ready []run, skipped []run) {
+	type outcome struct {
+		run
+		succeeded bool
+	}
+
+	// Walk the downstream jobs, a skipped job fails its own downstreams
+	pending := []outcome{{run{configId, logicalTime}, err == nil}}
+	for len(pending) > 0 {
+		job := pending[0]
+		pending = pending[1:]
+		for _, downstream := range d.downstreams[job.configId] {
+			start := d.start(downstream, job.logicalTime)
+			d.prune(downstream, start)
+			current := d.interval(downstream, start)
+			current.succeeded[job.configId] = job.succeeded
+			if current.resolved {
+				continue
+			}
+
+			done, failed := true, false
+			for _, upstream := range d.upstreams[downstream] {
+				succeeded, finished := current.succeeded[upstream]
+				done = done && finished
+				failed = failed || (finished && !succeeded)
+			}
+
+			switch {
+			case failed:
+				current.resolved = true
+				skipped = append(skipped, run{downstream, start})
+				pending = append(pending, outcome{run{downstream, start}, false})
+			case done:
+				current.resolved = true
+				ready = append(ready, run{downstream, start})
+			}
+		}
+	}
+
+	byId := func(a, b run) int { return cmp.Compare(a.configId, b.configId) }
+	slices.SortFunc(ready, byId)
+	slices.SortFunc(skipped, byId)
+	return ready, skipped
+}
+
+// interval returns the interval of a downstream job starting at start,
+// creating it when it is not tracked yet
+func (d *dependencies) interval(id string, start time.Time) *interval {
+	if current, ok := d.intervals[id][start]; ok {
+		return current
+	}
+	if d.intervals[id] == nil {
+		d.intervals[id] = make(map[time.Time]*interval)
+	}
+	current := &interval{succeeded: make(map[string]bool)}
+	d.intervals[id][start] = current
+	d.count++
+	d.evict()
+	return current
+}
+
+// prune forgets the resolved intervals of a downstream job that started
+// before start, intervals of other jobs are left alone
+func (d *dependencies) prune(id string, start time.Time) {
+	for logicalTime, current := range d.intervals[id] {
+		if current.resolved && logicalTime.Before(start) {
+			d.forget(id, logicalTime)
+		}
+	}
+}
+
+// evict drops the oldest intervals beyond maxIntervals
+func (d *dependencies) evict() {
+	for d.count > maxIntervals {
+		var oldestId string
+		var oldest time.Time
+		for id, intervals := range d.intervals {
+			for logicalTime := range intervals {
+				if oldestId == "" || logicalTime.Before(oldest) {
+					oldestId, oldest = id, logicalTime
+				}
+			}
+		}
+
+		current := d.intervals[oldestId][oldest]
+		if current.resolved {
+			slog.Debug("Dropping resolved dependency interval",
+				"config_id", oldestId,
+				"logical_time", oldest)
+		} else {
+			var waiting []string
+			for _, upstream := range d.upstreams[oldestId] {
+				if _, finished := current.succeeded[upstream]; !finished {
+					waiting = append(waiting, upstream)
+				}
+			}
+			slog.Warn("Dropping dependency interval, job will not run",
+				"config_id", oldestId,
+				"logical_time", oldest,
+				"waiting_for", waiting,
+				"finished", slices.Sorted(maps.Keys(current.succeeded)))
+		}
+		d.forget(oldestId, oldest)
+	}
+}
+
+// forget removes an interval of a downstream job
+func (d *dependencies) forget(id string, logicalTime time.Time) {
+	delete(d.intervals[id], logicalTime)
+	if len(d.intervals[id]) == 0 {
+		delete(d.intervals, id)
+	}
+	d.count--
+}
@@ -0,0 +1,143 @@
+package triggerer
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/parser"
+)
+
+// config builds a config that runs on cron or depends on other configs
+func config(id string, cron string, dependsOn ...string) parser.Config {
+	return parser.Config{
+		Id: id,
+		DataSource: parser.DataSource{
+			Trigger: parser.TriggerConfig{Cron: cron, DependsOn: dependsOn},
+		},
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	// customers and orders feed invoices, which feeds reports
+	configs := parser.Configs{
+		config("customers", ""),
+		config("orders", ""),
+		config("invoices", "", "customers", "orders"),
+		config("reports", "", "invoices"),
+	}
+	d := newDependencies(&configs)
+
+	first := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	failure := errors.New("failed")
+
+	tests := []struct {
+		name        string
+		configId    string
+		logicalTime time.Time
+		err         error
+		ready       []run
+		skipped     []run
+	}{
+		{name: "waits for every upstream", configId: "customers", logicalTime: first},
+		{name: "other interval is independent", configId: "orders", logicalTime: second},
+		{name: "all upstreams succeeded", configId: "orders", logicalTime: first, ready: []run{{"invoices", first}}},
+		{name: "chain continues", configId: "invoices", logicalTime: first, ready: []run{{"reports", first}}},
+		{name: "leaf completes", configId: "reports", logicalTime: first},
+		{name: "failure skips downstreams", configId: "customers", logicalTime: second, err: failure, skipped: []run{{"invoices", second}, {"reports", second}}},
+		{name: "late success after skip", configId: "orders", logicalTime: second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, skipped := d.complete(tt.configId, tt.logicalTime, tt.err)
+			if !slices.Equal(ready, tt.ready) {
+				t.Errorf("complete() ready = %v, want %v", ready, tt.ready)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("complete() skipped = %v, want %v", skipped, tt.skipped)
+			}
+		})
+	}
+
+	// The first interval was resolved and is forgotten once the second starts
+	for id, intervals := range d.intervals {
+		if _, ok := intervals[first]; ok {
+			t.Errorf("Finished interval of %s should be pruned", id)
+		}
+	}
+}
+
+func TestDependencySchedules(t *testing.T) {
+	// customers runs hourly and orders daily, invoices meets them once a day.
+	// audits depends on the hourly customers alone.
+	configs := parser.Configs{
+		config("customers", "0 * * * *"),
+		config("orders", "30 0 * * *"),
+		config("invoices", "", "customers", "orders"),
+		config("reports", "", "invoices"),
+		config("audits", "", "customers"),
+	}
+	d := newDependencies(&configs)
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	at := func(days, hours, minutes int) time.Time {
+		return day.AddDate(0, 0, days).Add(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute)
+	}
+
+	tests := []struct {
+		name        string
+		configId    string
+		logicalTime time.Time
+		ready       []run
+	}{
+		{name: "hourly upstream", configId: "customers", logicalTime: at(0, 1, 0), ready: []run{{"audits", at(0, 1, 0)}}},
+		{name: "daily upstream joins", configId: "orders", logicalTime: at(0, 0, 30), ready: []run{{"invoices", at(0, 0, 30)}}},
+		{name: "chain keeps interval", configId: "invoices", logicalTime: at(0, 0, 30), ready: []run{{"reports", at(0, 0, 30)}}},
+		{name: "later hour of same day", configId: "customers", logicalTime: at(0, 2, 0), ready: []run{{"audits", at(0, 2, 0)}}},
+		{name: "next day waits", configId: "customers", logicalTime: at(1, 1, 0), ready: []run{{"audits", at(1, 1, 0)}}},
+		{name: "next day", configId: "orders", logicalTime: at(1, 0, 30), ready: []run{{"invoices", at(1, 0, 30)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, skipped := d.complete(tt.configId, tt.logicalTime, nil)
+			if !slices.Equal(ready, tt.ready) {
+				t.Errorf("complete() ready = %v, want %v", ready, tt.ready)
+			}
+			if len(skipped) > 0 {
+				t.Errorf("complete() skipped = %v, want none", skipped)
+			}
+		})
+	}
+}
+
+func TestDependencyPruning(t *testing.T) {
+	// Two unrelated branches, b never finishes while a keeps running
+	configs := parser.Configs{
+		config("a", ""),
+		config("a.next", "", "a"),
+		config("b", ""),
+		config("c", ""),
+		config("b.next", "", "b", "c"),
+	}
+	d := newDependencies(&configs)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.complete("c", start, nil)
+	for i := range 2 * maxIntervals {
+		d.complete("a", start.Add(time.Duration(i)*time.Minute), nil)
+	}
+
+	if got := len(d.intervals["a.next"]); got != 1 {
+		t.Errorf("a.next has %d intervals, want only the latest", got)
+	}
+	if _, ok := d.intervals["b.next"][start]; !ok {
+		t.Error("Waiting interval of b.next should not be evicted by another branch")
+	}
+	if ready, _ := d.complete("b", start, nil); !slices.Equal(ready, []run{{"b.next", start}}) {
+		t.Errorf("complete() ready = %v, want b.next", ready)
+	}
+}
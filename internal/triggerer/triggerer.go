@@ -1,9 +1,16 @@
 package triggerer
 
+import (
+	"context"
+
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
+)
+
 type Triggerer interface {
 	Start() error
-	Stop() error
-	Post(configId string) error
+	Stop(ctx context.Context) error
+	Post(job scheduler.Job) error
+	Complete(job scheduler.Job, err error) error
 	RegisterQueue(config map[string]string) error
 	DeregisterQueue(queueId string) error
 }
@@ -1,12 +1,35 @@
 package validator
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/andrew-a-hale/mdf/internal/parser"
+	"github.com/apache/arrow-go/v18/arrow"
 )
 
+// Rules that a row can break
+const (
+	NOT_NULL = "not_null"
+	UNIQUE   = "unique"
+)
+
+// Error is the first row that breaks a validation rule
+type Error struct {
+	Rule  string
+	Field string
+	Row   int
+}
+
+func (e *Error) Error() string {
+	if e.Rule == NOT_NULL {
+		return fmt.Sprintf("validation error: field '%s' cannot be null (row %d)", e.Field, e.Row)
+	}
+	return fmt.Sprintf("validation error: field '%s' must be unique (row %d)", e.Field, e.Row)
+}
+
 // Validator handles data validation
 type Validator struct {
 	config parser.ValidationConfig
@@ -19,16 +42,109 @@ func New(config parser.ValidationConfig) *Validator {
 	}
 }
 
-// Validate validates a dataset against the validation rules
-func (v *Validator) Validate(data []map[string]any) error {
+// Validate validates a dataset against the validation rules, it stops with
+// ctx's error when ctx is done
+func (v *Validator) Validate(ctx context.Context, data []map[string]any) error {
+	return v.Stream().Validate(ctx, data)
+}
+
+// FirstError returns the error a Stream reports for a dataset validated in
+// batches of batchSize rows, given the first row breaking each rule, keyed by
+// field. It lets the rules be checked elsewhere, such as in SQL, with the same
+// result. It returns nil when no rule is broken.
+func (v *Validator) FirstError(notNull, unique map[string]int, batchSize int) error {
+	// A stream stops at the first batch with a broken rule
+	batch := -1
+	for _, rows := range []map[string]int{notNull, unique} {
+		for _, row := range rows {
+			if batch < 0 || row/batchSize < batch {
+				batch = row / batchSize
+			}
+		}
+	}
+	if batch < 0 {
+		return nil
+	}
+
+	// and checks every not null field of the batch before the unique ones
+	for _, rule := range []struct {
+		name   string
+		fields []string
+		rows   map[string]int
+	}{
+		{NOT_NULL, v.config.NotNull, notNull},
+		{UNIQUE, v.config.Unique, unique},
+	} {
+		for _, field := range rule.fields {
+			if row, ok := rule.rows[field]; ok && row/batchSize == batch {
+				return &Error{Rule: rule.name, Field: field, Row: row}
+			}
+		}
+	}
+	return nil
+}
+
+// Stream validates a dataset one batch at a time
+func (v *Validator) Stream() *Stream {
+	seen := make(map[string]map[any]bool, len(v.config.Unique))
+	for _, field := range v.config.Unique {
+		seen[field] = make(map[any]bool)
+	}
+	return &Stream{config: v.config, seen: seen}
+}
+
+// StreamDuplicates validates a dataset one batch at a time, given the first
+// repeated row of each unique field, keyed by field, as found by a
+// connectors.DuplicateFinder. Fields left out have no repeated values. The
+// stream holds no values of its own.
+func (v *Validator) StreamDuplicates(duplicates map[string]int) *Stream {
+	return &Stream{config: v.config, duplicates: duplicates}
+}
+
+// Stream validates the batches of a dataset. Unique fields are checked across
+// every batch, against the values of the earlier batches or the repeated rows
+// given to StreamDuplicates.
+type Stream struct {
+	config parser.ValidationConfig
+	// rows is the number of rows validated so far, rows are numbered across
+	// batches
+	rows int
+	// seen holds the values of the unique fields read so far, it is nil when
+	// the repeated rows are given in duplicates
+	seen       map[string]map[any]bool
+	duplicates map[string]int
+}
+
+// Validate validates the next batch of rows against the validation rules
+func (s *Stream) Validate(ctx context.Context, batch []map[string]any) error {
+	return s.validate(ctx, rowBatch(batch))
+}
+
+// ValidateRecord validates the next arrow record batch against the
+// validation rules
+func (s *Stream) ValidateRecord(ctx context.Context, batch arrow.Record) error {
+	return s.validate(ctx, newRecordBatch(batch))
+}
+
+// validate validates a batch against the validation rules, ctx is checked
+// before each rule
+func (s *Stream) validate(ctx context.Context, batch batch) error {
+	defer func() { s.rows += batch.Len() }()
+
 	// Validate not null fields
-	err := v.validateNotNull(data)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.validateNotNull(batch)
 	if err != nil {
 		return err
 	}
 
 	// Validate unique fields
-	err = v.validateUnique(data)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = s.validateUnique(batch)
 	if err != nil {
 		return err
 	}
@@ -37,15 +153,15 @@ func (v *Validator) Validate(data []map[string]any) error {
 }
 
 // validateNotNull checks that fields are not null
-func (v *Validator) validateNotNull(data []map[string]any) error {
-	for _, field := range v.config.NotNull {
-		for i, row := range data {
-			val, exists := row[field]
+func (s *Stream) validateNotNull(batch batch) error {
+	for _, field := range s.config.NotNull {
+		for i := range batch.Len() {
+			val, exists := batch.Value(field, i)
 			if !exists || val == nil {
 				slog.Error("Not null validation failed",
 					"field", field,
-					"row", i)
-				return fmt.Errorf("validation error: field '%s' cannot be null (row %d)", field, i)
+					"row", s.rows+i)
+				return &Error{Rule: NOT_NULL, Field: field, Row: s.rows + i}
 			}
 		}
 	}
@@ -53,11 +169,14 @@ func (v *Validator) validateNotNull(data []map[string]any) error {
 }
 
 // validateUnique checks that fields are unique
-func (v *Validator) validateUnique(data []map[string]any) error {
-	for _, field := range v.config.Unique {
-		values := make(map[any]bool)
-		for i, row := range data {
-			val, exists := row[field]
+func (s *Stream) validateUnique(batch batch) error {
+	if s.seen == nil {
+		return s.validateDuplicates(batch)
+	}
+	for _, field := range s.config.Unique {
+		values := s.seen[field]
+		for i := range batch.Len() {
+			val, exists := batch.Value(field, i)
 			if !exists {
 				continue
 			}
@@ -65,9 +184,9 @@ func (v *Validator) validateUnique(data []map[string]any) error {
 			if _, found := values[val]; found {
 				slog.Error("Unique validation failed",
 					"field", field,
-					"row", i,
+					"row", s.rows+i,
 					"value", val)
-				return fmt.Errorf("validation error: field '%s' must be unique (row %d)", field, i)
+				return &Error{Rule: UNIQUE, Field: field, Row: s.rows + i}
 			}
 
 			values[val] = true
@@ -75,3 +194,66 @@ func (v *Validator) validateUnique(data []map[string]any) error {
 	}
 	return nil
 }
+
+// validateDuplicates checks that no repeated row of a unique field is in the
+// batch
+func (s *Stream) validateDuplicates(batch batch) error {
+	for _, field := range s.config.Unique {
+		row, found := s.duplicates[field]
+		if !found || row < s.rows || row >= s.rows+batch.Len() {
+			continue
+		}
+		slog.Error("Unique validation failed",
+			"field", field,
+			"row", row)
+		return &Error{Rule: UNIQUE, Field: field, Row: row}
+	}
+	return nil
+}
+
+// batch gives the validation rules access to the rows of a batch
+type batch interface {
+	Len() int
+	// Value returns the value of a field in a row as a comparable key, nil
+	// when it is null, and false when the field is missing
+	Value(field string, row int) (any, bool)
+}
+
+// rowBatch is a batch of rows
+type rowBatch []map[string]any
+
+func (b rowBatch) Len() int { return len(b) }
+
+func (b rowBatch) Value(field string, row int) (any, bool) {
+	val, exists := b[row][field]
+	return val, exists
+}
+
+// recordBatch is an arrow record batch
+type recordBatch struct {
+	rec     arrow.Record
+	columns map[string]int
+}
+
+func newRecordBatch(rec arrow.Record) recordBatch {
+	columns := make(map[string]int, rec.NumCols())
+	for i, field := range rec.Schema().Fields() {
+		columns[field.Name] = i
+	}
+	return recordBatch{rec: rec, columns: columns}
+}
+
+func (b recordBatch) Len() int { return int(b.rec.NumRows()) }
+
+func (b recordBatch) Value(field string, row int) (any, bool) {
+	i, exists := b.columns[field]
+	if !exists {
+		return nil, false
+	}
+	col := b.rec.Column(i)
+	if col.IsNull(row) {
+		return nil, true
+	}
+	// Keys outlive the batch, so they must not point into its buffers
+	return strings.Clone(col.ValueStr(row)), true
+}
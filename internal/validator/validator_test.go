@@ -1,8 +1,12 @@
 package validator
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
+	"github.com/andrew-a-hale/mdf/internal/connectors"
 	"github.com/andrew-a-hale/mdf/internal/parser"
 )
 
@@ -36,7 +40,7 @@ func TestValidate(t *testing.T) {
 		{"id": "2", "name": "Bob", "age": 25},
 	}
 
-	err := v.Validate(validData)
+	err := v.Validate(context.Background(), validData)
 	if err != nil {
 		t.Errorf("Validate() error = %v, want nil", err)
 	}
@@ -47,7 +51,7 @@ func TestValidate(t *testing.T) {
 		{"id": "2", "name": "Bob", "age": 25},
 	}
 
-	err = v.Validate(nullData)
+	err = v.Validate(context.Background(), nullData)
 	if err == nil {
 		t.Error("Validate() with null fields should return error")
 	}
@@ -58,8 +62,93 @@ func TestValidate(t *testing.T) {
 		{"id": "1", "name": "Bob", "age": 25}, // Duplicate ID
 	}
 
-	err = v.Validate(duplicateData)
+	err = v.Validate(context.Background(), duplicateData)
 	if err == nil {
 		t.Error("Validate() with duplicate IDs should return error")
 	}
 }
+
+func TestStream(t *testing.T) {
+	v := New(parser.ValidationConfig{
+		NotNull: []string{"id"},
+		Unique:  []string{"id"},
+	})
+
+	// Rows are numbered across batches
+	s := v.Stream()
+	if err := s.Validate(context.Background(), []map[string]any{{"id": "1"}, {"id": "2"}}); err != nil {
+		t.Fatalf("Validate() error = %v, want nil", err)
+	}
+	err := s.Validate(context.Background(), []map[string]any{{"id": "3"}, {"id": nil}})
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("Validate() error = %v, want a null id at row 3", err)
+	}
+
+	// Unique fields are checked across batches
+	s = v.Stream()
+	if err := s.Validate(context.Background(), []map[string]any{{"id": "1"}}); err != nil {
+		t.Fatalf("Validate() error = %v, want nil", err)
+	}
+	err = s.Validate(context.Background(), []map[string]any{{"id": "2"}, {"id": "1"}})
+	if err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Errorf("Validate() error = %v, want a duplicate id at row 2", err)
+	}
+
+	// Each stream starts empty
+	if err := v.Stream().Validate(context.Background(), []map[string]any{{"id": "1"}}); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	// Repeated rows found up front are reported in their batch, after the
+	// not null fields
+	s = v.StreamDuplicates(map[string]int{"id": 3})
+	if err := s.Validate(context.Background(), []map[string]any{{"id": "1"}, {"id": "2"}}); err != nil {
+		t.Fatalf("Validate() error = %v, want nil", err)
+	}
+	err = s.Validate(context.Background(), []map[string]any{{"id": "3"}, {"id": "1"}})
+	if err == nil || !strings.Contains(err.Error(), "must be unique (row 3)") {
+		t.Errorf("Validate() error = %v, want a duplicate id at row 3", err)
+	}
+	err = v.StreamDuplicates(map[string]int{"id": 1}).Validate(context.Background(), []map[string]any{{"id": nil}, {"id": nil}})
+	if err == nil || !strings.Contains(err.Error(), "cannot be null (row 0)") {
+		t.Errorf("Validate() error = %v, want a null id at row 0", err)
+	}
+	if err := v.StreamDuplicates(nil).Validate(context.Background(), []map[string]any{{"id": "1"}, {"id": "1"}}); err != nil {
+		t.Errorf("Validate() error = %v, want nil without repeated rows", err)
+	}
+
+	// A cancelled stream stops before checking the batch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := v.Stream().Validate(ctx, []map[string]any{{"id": nil}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Validate() error = %v, want context.Canceled", err)
+	}
+
+	// Arrow record batches follow the same rules
+	schema, err := connectors.Schema([]parser.FieldConfig{{Label: "id", DataType: "int"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = v.Stream()
+	for i, tt := range []struct {
+		rows []map[string]any
+		want string
+	}{
+		{rows: []map[string]any{{"id": 1}, {"id": 2}}},
+		{rows: []map[string]any{{"id": 3}, {"id": 2}}, want: "must be unique (row 3)"},
+		{rows: []map[string]any{{"id": nil}}, want: "cannot be null (row 4)"},
+	} {
+		rec, err := connectors.NewRecord(schema, tt.rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.ValidateRecord(context.Background(), rec)
+		rec.Release()
+		if tt.want == "" && err != nil {
+			t.Errorf("ValidateRecord() batch %d error = %v, want nil", i, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("ValidateRecord() batch %d error = %v, want %q", i, err, tt.want)
+		}
+	}
+}
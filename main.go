@@ -1,78 +1,142 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
+	_ "github.com/andrew-a-hale/mdf/internal/connectors/filesystem" // Import for side effect of registering connector
 	"github.com/andrew-a-hale/mdf/internal/parser"
-	"github.com/andrew-a-hale/mdf/internal/scheduler"
-	"github.com/andrew-a-hale/mdf/internal/triggerer"
 )
 
-func main() {
-	// Setup structured logging
-	logHandler := slog.NewJSONHandler(os.Stdout, nil)
-	slog.SetDefault(slog.New(logHandler))
+// Exit codes shared by every subcommand
+const (
+	EXIT_OK = 0
+	// EXIT_FAILURE is returned when a command fails, such as a failed job
+	EXIT_FAILURE = 1
+	// EXIT_USAGE is returned for unknown subcommands, flags or arguments
+	EXIT_USAGE = 2
+	// EXIT_INVALID_CONFIG is returned when the configs cannot be parsed or fail
+	// validation
+	EXIT_INVALID_CONFIG = 3
+	// EXIT_NOT_FOUND is returned when a config id does not exist
+	EXIT_NOT_FOUND = 4
+	// EXIT_NOT_DRAINED is returned by the daemon when jobs were still running
+	// at the shutdown deadline and were cancelled
+	EXIT_NOT_DRAINED = 5
+)
 
-	configDir := flag.String("config-dir", "configs", "Path to the directory containing configuration files")
-	flag.Parse()
+// command is a subcommand of mdf
+type command struct {
+	name    string
+	usage   string
+	run     func(args []string) int
+	logging bool
+}
 
-	if *configDir == "" {
-		slog.Error("No config directory provided", "flag", "-config-dir")
-		os.Exit(1)
+// commands are the subcommands of mdf. Only the daemon logs JSON to stdout,
+// the others log text to stderr so their output can be piped.
+var commands = []command{
+	{name: "daemon", usage: "Run the triggerer and scheduler until interrupted", run: daemon, logging: true},
+	{name: "run", usage: "Execute a single config synchronously: run <id>", run: run},
+	{name: "validate", usage: "Parse and validate every config", run: validate},
+	{name: "list", usage: "List configs with their triggers and next fire times", run: list},
+	{name: "render", usage: "Print the effective configs: render [id ...]", run: render},
+	{name: "schema", usage: "Print the JSON Schema of the config format", run: schema},
+	{name: "dlq", usage: "Inspect and replay dead lettered jobs: dlq list|retry|purge", run: dlq},
+	{name: "config", usage: "Maintain config files: config migrate|render", run: configCommand},
+}
+
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// dispatch runs the subcommand named by the first argument
+func dispatch(args []string) int {
+	// Flags without a subcommand start the daemon, as before subcommands
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" {
+		args = append([]string{"daemon"}, args...)
+	}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage()
+		return EXIT_USAGE
 	}
 
-	// Parse configuration directory
-	slog.Info("Using config directory", "dir", *configDir)
-	config, err := parser.ParseConfigDirectory(*configDir)
-	if err != nil {
-		slog.Error("Failed to parse config directory", "error", err, "dir", *configDir)
-		os.Exit(1)
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		// Setup structured logging
+		var logHandler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+		if cmd.logging {
+			logHandler = slog.NewJSONHandler(os.Stdout, nil)
+		}
+		slog.SetDefault(slog.New(logHandler))
+
+		return cmd.run(args[1:])
 	}
 
-	// Initialize and start the Triggerer
-	triggerer := triggerer.New(config)
-	err = triggerer.Start()
-	if err != nil {
-		slog.Error("Failed to start triggerer", "error", err)
-		os.Exit(1)
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage()
+	return EXIT_USAGE
+}
+
+// usage prints the subcommands to stderr
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mdf <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
 	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run mdf <command> -h for the flags of a command.")
+}
 
-	slog.Info("Triggerer is running in background...")
+// configSource is where a command loads its configs from
+type configSource struct {
+	dir     string
+	profile string
+}
+
+// configFlags registers the flags selecting the configs to load
+func configFlags(flags *flag.FlagSet) *configSource {
+	source := &configSource{}
+	flags.StringVar(&source.dir, "config-dir", "configs", "Directory or URI (file://, bundle://, s3://) containing configuration files")
+	flags.StringVar(&source.profile, "profile", os.Getenv("MDF_PROFILE"), "Profile whose overrides are applied to the configs, such as dev or prod")
+	return source
+}
 
-	// Initialize and start the Scheduler
-	scheduler := scheduler.New()
-	err = scheduler.Start()
+// load parses the configs, reporting every validation issue
+func (s *configSource) load() (*parser.Configs, int) {
+	configs, code, err := s.parse()
 	if err != nil {
-		slog.Error("Failed to start scheduler", "error", err)
-		os.Exit(1)
+		var verr *parser.ValidationError
+		if errors.As(err, &verr) {
+			for _, issue := range verr.Issues {
+				slog.Error("Invalid config", "issue", issue.String())
+			}
+		}
+		slog.Error("Failed to parse config directory", "error", err, "dir", s.dir)
 	}
+	return configs, code
+}
 
-	slog.Info("Scheduler is running in background, waiting for jobs")
-
-	// Setup signal handling for graceful shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	// Keepalive ticker for logging that process is still running
-	keepaliveTicker := time.NewTicker(30 * time.Minute)
-	defer keepaliveTicker.Stop()
-
-	// Keep process alive until interrupted
-	for {
-		select {
-		case sig := <-sigCh:
-			slog.Info("Received signal, shutting down", "signal", sig.String())
-			triggerer.Stop()
-			// scheduler.Stop()
-			slog.Info("Triggerer stopped, exiting")
-			return
-		case <-keepaliveTicker.C:
-			slog.Info("Triggerer is still running")
-		}
+// parse parses the configs and returns the exit code of the outcome, a usage
+// error when no config directory is given
+func (s *configSource) parse() (*parser.Configs, int, error) {
+	if s.dir == "" {
+		slog.Error("No config directory provided", "flag", "-config-dir")
+		return nil, EXIT_USAGE, nil
+	}
+
+	configs, err := parser.ParseConfigRemoteDirectory(s.dir, parser.WithProfile(s.profile))
+	if err != nil {
+		return nil, EXIT_INVALID_CONFIG, err
 	}
+	return configs, EXIT_OK, nil
 }
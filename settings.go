@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andrew-a-hale/mdf/internal/scheduler"
+	"gopkg.in/yaml.v3"
+)
+
+// daemonSettings configure the daemon. They are read from the -settings file,
+// then MDF_* environment variables, then flags, each overriding the last.
+type daemonSettings struct {
+	ReloadInterval  time.Duration  `yaml:"reload_interval"`
+	ShutdownTimeout time.Duration  `yaml:"shutdown_timeout"`
+	Workers         int            `yaml:"workers"`
+	FailurePolicy   string         `yaml:"failure_policy"`
+	WorkDir         string         `yaml:"work_dir"`
+	Broker          brokerSettings `yaml:"broker"`
+}
+
+// brokerSettings select and configure the job queue
+type brokerSettings struct {
+	// Kind is memory or rabbitmq
+	Kind string `yaml:"kind"`
+	// QueueSize is the capacity of the memory broker
+	QueueSize                 int `yaml:"queue_size"`
+	scheduler.RabbitMQOptions `yaml:",inline"`
+}
+
+// settingsFlags registers the flags of the daemon settings, with their
+// defaults
+func settingsFlags(flags *flag.FlagSet) *daemonSettings {
+	s := &daemonSettings{}
+	flags.DurationVar(&s.ReloadInterval, "reload-interval", 0, "Interval to check the config directory for changes, 0 reloads on SIGHUP only")
+	flags.DurationVar(&s.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time running jobs are given to finish on shutdown before they are cancelled")
+	flags.IntVar(&s.Workers, "workers", 4, "Number of jobs run at once")
+	flags.StringVar(&s.FailurePolicy, "failure-policy", string(scheduler.DEAD_LETTER), "What happens to failed jobs without a retry policy, requeue once or dead_letter")
+	flags.StringVar(&s.WorkDir, "work-dir", "", "Root that relative connector paths are resolved against, the working directory when empty")
+	brokerFlags(flags, &s.Broker)
+	return s
+}
+
+// settingsFileFlag registers the flag naming the settings file
+func settingsFileFlag(flags *flag.FlagSet) *string {
+	return flags.String("settings", os.Getenv("MDF_SETTINGS"), "YAML file of daemon settings, overridden by MDF_* environment variables and flags")
+}
+
+// brokerFlags registers the flags of the broker settings, with their defaults
+func brokerFlags(flags *flag.FlagSet, b *brokerSettings) {
+	flags.StringVar(&b.Kind, "broker", "memory", "Job queue backend, memory for a single process or rabbitmq")
+	flags.IntVar(&b.QueueSize, "queue-size", 1024, "Number of jobs the memory broker queues before triggers block")
+	flags.StringVar(&b.URL, "broker-url", "amqp://localhost:5672/", "URL of the rabbitmq broker, amqps:// for TLS")
+	flags.StringVar(&b.Username, "broker-username", "", "Rabbitmq user, overrides the credentials of the URL")
+	flags.StringVar(&b.Password, "broker-password", "", "Rabbitmq password, prefer MDF_BROKER_PASSWORD")
+	flags.StringVar(&b.Vhost, "broker-vhost", "", "Rabbitmq virtual host, overrides the vhost of the URL")
+	flags.StringVar(&b.TLS.CAFile, "broker-tls-ca", "", "PEM file of the authorities trusted to sign the broker certificate")
+	flags.StringVar(&b.TLS.CertFile, "broker-tls-cert", "", "PEM file of the client certificate")
+	flags.StringVar(&b.TLS.KeyFile, "broker-tls-key", "", "PEM file of the client key")
+	flags.StringVar(&b.TLS.ServerName, "broker-tls-server-name", "", "Host name the broker certificate is checked against")
+	flags.DurationVar(&b.Heartbeat, "broker-heartbeat", 10*time.Second, "Interval of heartbeats negotiated with rabbitmq")
+	flags.DurationVar(&b.ReconnectInterval, "broker-reconnect-interval", 5*time.Second, "Wait between attempts to reach rabbitmq, on start and after the connection is lost")
+	flags.StringVar(&b.Exchange, "broker-exchange", "mdf", "Rabbitmq exchange jobs are published to")
+	flags.StringVar(&b.Queue, "broker-queue", "mdf.jobs", "Rabbitmq queue jobs are routed to")
+	flags.StringVar(&b.DeadLetterExchange, "broker-dead-letter-exchange", "mdf.dead", "Rabbitmq exchange rejected jobs are routed to, empty drops them")
+	flags.BoolVar(&b.Durable, "broker-durable", true, "Declare rabbitmq exchanges and queues that survive a restart and publish persistent jobs")
+	flags.IntVar(&b.Prefetch, "broker-prefetch", 10, "Number of unacknowledged jobs rabbitmq delivers at once")
+}
+
+// envName returns the environment variable of a flag, MDF_BROKER_URL for
+// broker-url
+func envName(flagName string) string {
+	return "MDF_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// load applies the settings file at path, then the environment, over the
+// parsed flags. Flags set on the command line keep their value.
+func (s *daemonSettings) load(flags *flag.FlagSet, path string) error {
+	explicit := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read settings file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse settings file %s: %w", path, err)
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if serr := flags.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("invalid %s: %w", envName(f.Name), serr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid -%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSettingsLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "settings.yaml")
+	content := `workers: 2
+work_dir: /file
+shutdown_timeout: 1m
+broker:
+  kind: rabbitmq
+  url: amqp://file:5672/
+  queue: file.jobs
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unknown := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknown, []byte("wokers: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		want    daemonSettings
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			want: daemonSettings{Workers: 4, ShutdownTimeout: 30 * time.Second, Broker: brokerSettings{Kind: "memory"}},
+		},
+		{
+			name: "File Over Defaults",
+			file: file,
+			want: daemonSettings{Workers: 2, ShutdownTimeout: time.Minute, WorkDir: "/file", Broker: brokerSettings{Kind: "rabbitmq"}},
+		},
+		{
+			name: "Env Over File",
+			file: file,
+			env:  map[string]string{"MDF_WORKERS": "3", "MDF_BROKER_QUEUE": "env.jobs", "MDF_WORK_DIR": "/env"},
+			want: daemonSettings{Workers: 3, ShutdownTimeout: time.Minute, WorkDir: "/env", Broker: brokerSettings{Kind: "rabbitmq"}},
+		},
+		{
+			name: "Flag Over Env",
+			file: file,
+			env:  map[string]string{"MDF_WORKERS": "3", "MDF_BROKER": "memory", "MDF_WORK_DIR": "/env"},
+			args: []string{"-workers", "5", "-shutdown-timeout", "10s", "-work-dir", "/flag"},
+			want: daemonSettings{Workers: 5, ShutdownTimeout: 10 * time.Second, WorkDir: "/flag", Broker: brokerSettings{Kind: "memory"}},
+		},
+		{
+			name: "Flag Set To Default Over Env",
+			env:  map[string]string{"MDF_WORKERS": "3"},
+			args: []string{"-workers", "4"},
+			want: daemonSettings{Workers: 4, ShutdownTimeout: 30 * time.Second, Broker: brokerSettings{Kind: "memory"}},
+		},
+		{name: "Invalid Env", env: map[string]string{"MDF_WORKERS": "many"}, wantErr: true},
+		{name: "Missing File", file: filepath.Join(dir, "missing.yaml"), wantErr: true},
+		{name: "Unknown Key", file: unknown, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			s := settingsFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.load(flags, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if s.Workers != tt.want.Workers {
+				t.Errorf("Workers = %d, want %d", s.Workers, tt.want.Workers)
+			}
+			if s.ShutdownTimeout != tt.want.ShutdownTimeout {
+				t.Errorf("ShutdownTimeout = %v, want %v", s.ShutdownTimeout, tt.want.ShutdownTimeout)
+			}
+			if s.WorkDir != tt.want.WorkDir {
+				t.Errorf("WorkDir = %q, want %q", s.WorkDir, tt.want.WorkDir)
+			}
+			if s.Broker.Kind != tt.want.Broker.Kind {
+				t.Errorf("Broker.Kind = %q, want %q", s.Broker.Kind, tt.want.Broker.Kind)
+			}
+		})
+	}
+
+	// Nested broker settings follow the same precedence
+	t.Run("Broker Options", func(t *testing.T) {
+		t.Setenv("MDF_BROKER_QUEUE", "env.jobs")
+		flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+		s := settingsFlags(flags)
+		if err := flags.Parse([]string{"-broker-exchange", "flag"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.load(flags, file); err != nil {
+			t.Fatal(err)
+		}
+		if s.Broker.URL != "amqp://file:5672/" {
+			t.Errorf("Broker.URL = %q, want the file value", s.Broker.URL)
+		}
+		if s.Broker.Queue != "env.jobs" {
+			t.Errorf("Broker.Queue = %q, want the env value", s.Broker.Queue)
+		}
+		if s.Broker.Exchange != "flag" {
+			t.Errorf("Broker.Exchange = %q, want the flag value", s.Broker.Exchange)
+		}
+		if s.Broker.Prefetch != 10 {
+			t.Errorf("Broker.Prefetch = %d, want the default", s.Broker.Prefetch)
+		}
+	})
+}